@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+)
+
+// Stable stand-ins for identifying strings, numbered in first-seen order
+type pseudonyms struct {
+	prefix string
+	names  map[string]string
+}
+
+func (p *pseudonyms) get(name string) string {
+	if pseudonym, ok := p.names[name]; ok {
+		return pseudonym
+	}
+	pseudonym := fmt.Sprintf("%s-%02d", p.prefix, len(p.names)+1)
+	p.names[name] = pseudonym
+	return pseudonym
+}
+
+// -anonymize: replaces session names and log file paths in every query
+// result, so the TUI and exports can be shared without revealing products
+// or paths. The mapping lasts for the run, so rows still line up across polls.
+// Sessions keep their real name for -pin, -expect, -policy and -tags.
+type anonymizer struct {
+	mu       sync.Mutex
+	sessions pseudonyms
+	logFiles pseudonyms
+}
+
+func newAnonymizer() *anonymizer {
+	return &anonymizer{
+		sessions: pseudonyms{prefix: "session", names: make(map[string]string)},
+		logFiles: pseudonyms{prefix: "logfile", names: make(map[string]string)},
+	}
+}
+
+// Replace the identifying fields of sessions in place. Log files keep
+// their extension, which tells an .etl from other targets.
+func (a *anonymizer) apply(sessions []ETWSession) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := range sessions {
+		sessions[i].realName = sessions[i].Name
+		sessions[i].Name = a.sessions.get(sessions[i].Name)
+		if sessions[i].LogFileName != "" {
+			sessions[i].LogFileName = a.logFiles.get(sessions[i].LogFileName) + filepath.Ext(sessions[i].LogFileName)
+		}
+	}
+}
+
+// Pseudonym of a session name given on the command line, such as a -pin
+// name not running. It is numbered when first asked for, like a session
+// first seen in a query.
+func (a *anonymizer) name(name string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.sessions.get(name)
+}
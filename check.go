@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Exit codes of the check command, so scheduled tasks and monitoring agents
+// can tell a failed query apart from sessions that need attention, and a
+// real-time consumer that can't keep up apart from other warnings
+const (
+	exitOK      = 0
+	exitError   = 1
+	exitWarning = 2
+	exitRTLost  = 3
+)
+
+// Time between the two queries taken when the policy sets loss budgets,
+// which are rates and can't be judged from a single query
+const lossBudgetSample = 5 * time.Second
+
+// Query once and print a line per session in a warning state. A single
+// query has no interval, so losses are judged on the cumulative counters,
+// except against loss budgets, for which a second query is taken.
+func (m *ETWBufferMonitor) Check(opts monitorOptions) int {
+	opts.showOnce = true
+	sessions, err := m.QueryAllSessions()
+	if err != nil {
+		fmt.Printf("ERROR: querying sessions: %v\n", err)
+		return exitError
+	}
+	state := initialModel(m, opts)
+	if opts.policy.hasLossBudgets() {
+		state.recordHistory(sessions, time.Now())
+		time.Sleep(lossBudgetSample)
+		if sessions, err = m.QueryAllSessions(); err != nil {
+			fmt.Printf("ERROR: querying sessions: %v\n", err)
+			return exitError
+		}
+		state.recordHistory(sessions, time.Now())
+	}
+	state.sessions = sessions
+
+	status := exitOK
+	for _, session := range sessions {
+		conditions := state.sessionConditions(session)
+		if i := slices.Index(conditions, "policy-violation"); i >= 0 {
+			conditions[i] += " (" + describeViolations(state.policyViolations(session)) + ")"
+		}
+		if opts.checkLogFiles {
+			if problem := checkLogFile(session); problem != "" {
+				conditions = append(conditions, "log-file ("+problem+")")
+			}
+		}
+		if len(conditions) > 0 {
+			fmt.Printf("WARN: %s: %s\n", session.Key(), strings.Join(conditions, ", "))
+			if state.losingRealTimeBuffers(session) {
+				status = exitRTLost
+			} else {
+				status = max(status, exitWarning)
+			}
+		}
+	}
+
+	for _, name := range state.missingExpected() {
+		fmt.Printf("WARN: %s: missing\n", name)
+		status = max(status, exitWarning)
+	}
+
+	if len(m.hosts) > 0 {
+		hostStatus := m.HostStatus()
+		hosts := make([]string, 0, len(hostStatus))
+		for host := range hostStatus {
+			hosts = append(hosts, host)
+		}
+		slices.Sort(hosts)
+		for _, host := range hosts {
+			if hostStatus[host] != nil {
+				fmt.Printf("WARN: host %s not answering: %v\n", host, hostStatus[host])
+				status = max(status, exitWarning)
+			}
+		}
+	}
+
+	if status == exitOK {
+		fmt.Printf("OK: %d sessions, no warnings\n", len(sessions))
+	}
+	return status
+}
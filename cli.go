@@ -0,0 +1,861 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Values accepted by -query-api
+var queryAPIs = []string{"all", "single"}
+
+// Default targets of the export flags and -api
+const (
+	defaultCSVFile  = "etw_buffer_stats.csv"
+	defaultJSONFile = "etw_buffer_stats.json"
+	defaultTextFile = "etw_buffer_stats.txt"
+	defaultAPIAddr  = ":8080"
+)
+
+// A subcommand with its own flag set. The usage text is generated from the
+// flag set so help can't drift from what is actually parsed.
+type command struct {
+	name     string
+	synopsis string
+	summary  string
+	flags    func(fs *flag.FlagSet, cfg *cliConfig)
+	run      func(cfg *cliConfig, args []string) int
+}
+
+var commands = []command{
+	{
+		name:     "monitor",
+		synopsis: "[monitor] [options]",
+		summary:  "Show the live session table (default when no command is given)",
+		flags:    addMonitorFlags,
+		run:      runMonitor,
+	},
+	{
+		name:     "export",
+		synopsis: "export [options] [file]",
+		summary:  "Write the current sessions to a CSV, JSON or plain-text table file (default: etw_buffer_stats.csv, .json or .txt)",
+		flags:    addExportFlags,
+		run:      runExport,
+	},
+	{
+		name:     "check",
+		synopsis: "check [options]",
+		summary:  "Query once and report sessions that need attention; exits 0 when healthy, 1 on error and 2 on warnings",
+		flags:    addCheckFlags,
+		run:      runCheck,
+	},
+	{
+		name:     "diagnose",
+		synopsis: "diagnose [options]",
+		summary:  "Print diagnostic information for bug reports and exit",
+		flags:    addDiagnoseFlags,
+		run:      func(cfg *cliConfig, args []string) int { return boolExit(runDiagnostics(cfg.dumpRaw)) },
+	},
+}
+
+// Everything the flag sets write into before a command runs
+type cliConfig struct {
+	monitor      *ETWBufferMonitor
+	opts         monitorOptions
+	cooldown     int
+	rtLost       int
+	logMaxSize   string
+	pinned       listFlag
+	expected     listFlag
+	hosts        listFlag
+	computer     string
+	anonymize    bool
+	format       string
+	rawJSON      bool
+	fields       listFlag
+	changeFields listFlag
+	jsonFields   []int
+	clip         bool
+	legacyOnce   bool
+	legacyExport string
+	legacyJSON   string
+	legacyText   string
+	etlFile      string
+	stopSession  string
+	session      string
+	pushGateway  string
+	pushJob      string
+	pushInstance string
+	logFormat    string
+	logLevel     string
+	serveAPI     string
+	legacyDiag   bool
+	dumpRaw      string
+	themeFile    string
+	requireAdmin bool
+	noColor      bool
+	explicit     map[string]bool // Flags given on the command line, which override detected defaults
+	tagsFile     string
+	policyFile   string
+}
+
+func newCLIConfig() *cliConfig {
+	return &cliConfig{
+		monitor:   NewETWBufferMonitor(),
+		opts:      monitorOptions{intervalSeconds: 1, depleteRate: 10, warnRTLost: 1, rateWindow: 1, idlePolls: 3, layout: "top", timeFormat: "absolute", sortKey: "name", gauge: "numeric", detailMode: "snapshot", onWarnCooldown: time.Minute, reconcileEvery: 5 * time.Minute},
+		cooldown:  60,
+		rtLost:    1,
+		pushJob:   "etwtop",
+		logFormat: "text",
+		logLevel:  "info",
+		format:    "csv",
+	}
+}
+
+// A comma-separated list flag that may also be repeated
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// Flags that choose where sessions come from, shared by every command that queries
+func addSourceFlags(fs *flag.FlagSet, cfg *cliConfig) {
+	fs.StringVar(&cfg.computer, "computer", "", "Show the export another machine publishes to `\\\\HOST`\\ETWtop (or a full CSV path)")
+	fs.Var(&cfg.hosts, "hosts", "Show the combined feeds of several -api instances (`h1:port,...`)")
+	fs.BoolVar(&cfg.anonymize, "anonymize", false, "Replace session names and log file paths with stable pseudonyms (session-01, ...) for sharing")
+	fs.StringVar(&cfg.monitor.queryAPI, "query-api", cfg.monitor.queryAPI, "Query `api`: all lists every session with QueryAllTracesW, single queries the -pin and -expect names with QueryTraceW")
+}
+
+func addAdminFlag(fs *flag.FlagSet, cfg *cliConfig) {
+	fs.BoolVar(&cfg.requireAdmin, "require-admin", false, "Exit with an error instead of warning when not running elevated")
+	fs.BoolVar(&quiet, "quiet", false, "Leave out the elevation warning, banners and progress messages, keeping stdout machine-readable")
+}
+
+// Flags for the tool's own activity log, shared by every command that queries
+func addLogFlags(fs *flag.FlagSet, cfg *cliConfig) {
+	fs.StringVar(&cfg.opts.logFile, "logfile", "", "Write the tool's activity (polls, exports, hook runs, errors) to a `file`")
+	fs.StringVar(&cfg.logFormat, "log-format", cfg.logFormat, "Activity log `format`: text or json")
+	fs.StringVar(&cfg.logLevel, "log-level", cfg.logLevel, "Activity log `level`: debug (adds every poll), info, warn or error")
+}
+
+func addMonitorFlags(fs *flag.FlagSet, cfg *cliConfig) {
+	fs.IntVar(&cfg.opts.intervalSeconds, "interval", cfg.opts.intervalSeconds, "Monitoring interval in `seconds`")
+	fs.IntVar(&cfg.opts.count, "count", 0, "Quit after `N` refreshes (default: run until quit)")
+	fs.StringVar(&cfg.opts.runReport, "run-report", "", "Also write the report printed on quit (duration, peaks, events lost) to a `file`")
+	fs.StringVar(&cfg.opts.exportHistory, "export-history", "", "On quit, write each session's recent samples (utilization, events lost, buffers written) as JSON to a `file`")
+	fs.BoolVar(&cfg.opts.headless, "headless", false, "Poll without the TUI, printing a line per refresh; use with -log-csv, -on-warn and -count")
+	fs.BoolVar(&cfg.opts.tailNew, "tail-new", false, "Run headless and print a line only when a session appears (with its buffer setup) or disappears")
+	fs.DurationVar(&cfg.opts.reconcileEvery, "reconcile", cfg.opts.reconcileEvery, "Every `duration`, drop all tracking state of sessions no longer running, for multi-day runs (0: never)")
+	fs.BoolVar(&cfg.opts.stream, "stream", false, "Run headless and write every refresh's sessions to stdout as NDJSON, one session per line (honours -fields)")
+	fs.Var(&cfg.changeFields, "change-fields", "Session `fields` whose movement counts as a change, e.g. EventsLost (default: "+strings.Join(defaultChangeFields, ",")+")")
+	fs.BoolVar(&cfg.opts.adaptive, "adaptive", false, "Double the interval while idle, reset on any change")
+	fs.BoolVar(&cfg.opts.perSecond, "per-second", false, "Show the Wr/Int and Lost/Int columns as buffers written and events lost per second (Wr/s, Lost/s)")
+	fs.IntVar(&cfg.opts.rateWindow, "rate-window", cfg.opts.rateWindow, "Average the Wr/Int and Lost/Int columns over the last `N` intervals")
+	fs.IntVar(&cfg.opts.idlePolls, "idle-polls", cfg.opts.idlePolls, "Count a session as idle after `N` polls without buffers written (i hides idle sessions)")
+	addRTLostFlag(fs, cfg)
+	fs.Float64Var(&cfg.opts.depleteRate, "deplete-rate", cfg.opts.depleteRate, "Warn when free buffers drop by this `%` of buffers in one interval")
+	fs.Var(&cfg.pinned, "pin", "Always show these sessions, marked when not present (`name1,name2`)")
+	fs.Var(&cfg.expected, "expect", "Sessions that must be running; shown as MISSING and warned about when absent (`name1,name2`)")
+	fs.StringVar(&cfg.opts.gauge, "gauge", cfg.opts.gauge, "Utilization column `mode`: "+strings.Join(gaugeModes, ", "))
+	fs.IntVar(&cfg.opts.rollup, "rollup", 0, "Start grouped by the first `N` dash-separated name segments, with totals per group (u toggles)")
+	fs.StringVar(&cfg.opts.detailMode, "detail", cfg.opts.detailMode, "Detail pane `mode` on new queries: "+strings.Join(detailModes, ", "))
+	fs.StringVar(&cfg.opts.sortKey, "sort", cfg.opts.sortKey, "Initial sort `column`: "+strings.Join(sortKeys, ", "))
+	fs.BoolVar(&cfg.opts.sortDesc, "desc", false, "Sort descending")
+	fs.StringVar(&cfg.opts.logCSV, "log-csv", "", "Append every refresh to a CSV `file` while monitoring")
+	fs.StringVar(&cfg.opts.serveMetrics, "serve", "", "Serve the latest refresh as Prometheus metrics at http://`addr`/metrics while monitoring")
+	fs.StringVar(&cfg.opts.serveMetrics, "prometheus", "", "Same as -serve `addr`")
+	fs.StringVar(&cfg.logMaxSize, "log-max-size", "", "Rotate the CSV log at this `size`, e.g. 10MB (default: never)")
+	fs.StringVar(&cfg.opts.logRotate, "log-rotate", "", "Also rotate the CSV log at each hour or day boundary (`period`: hourly or daily)")
+	fs.IntVar(&cfg.opts.logMaxFiles, "log-max-files", 0, "Rotated CSV logs to `keep` (default: all)")
+	fs.DurationVar(&cfg.opts.flushInterval, "flush-interval", 0, "Buffer CSV log rows and write them at most every `duration`, e.g. 10s (default: every refresh)")
+	fs.StringVar(&cfg.opts.onWarn, "on-warn", "", "Run a `command` when a session enters a warning state (%SESSION% and %CONDITION% are substituted)")
+	fs.IntVar(&cfg.cooldown, "on-warn-cooldown", cfg.cooldown, "Minimum `seconds` between -on-warn runs per session and condition")
+	fs.BoolVar(&cfg.opts.checkLogFiles, "check-logfiles", false, "Warn when file-backed sessions can't write their log file")
+	fs.BoolVar(&cfg.opts.hideWriteDelta, "no-write-delta", false, "Hide the buffers-written-per-interval column")
+	fs.BoolVar(&cfg.opts.hideLegend, "no-legend", false, "Start with the color legend hidden (toggle with ?)")
+	fs.BoolVar(&cfg.opts.hideSummary, "no-summary", false, "Start with the summary box hidden (toggle with t)")
+	fs.BoolVar(&cfg.opts.hideWarnings, "no-warnings", false, "Start with the warning box hidden (toggle with w)")
+	fs.BoolVar(&cfg.opts.human, "human", false, "Abbreviate large Written and Lost counts in the table (12.3K, 1.2M)")
+	fs.BoolVar(&cfg.opts.icons, "icons", false, "Show health, session type (file/real-time) and trend glyphs")
+	fs.BoolVar(&cfg.opts.grid, "grid", false, "Show each session as a colored block in a heatmap laid out to the terminal width, for hundreds of sessions")
+	fs.BoolVar(&cfg.opts.arrows, "arrows", false, "Mark buffer and loss counts with ↑/↓ when they moved since the previous refresh")
+	fs.BoolVar(&cfg.opts.ascii, "ascii", false, "Draw with ASCII only (borders, rules, glyphs); default: on when the console isn't UTF-8")
+	fs.StringVar(&cfg.opts.layout, "layout", cfg.opts.layout, "Put the status lines at the `top` or the bottom of the screen")
+	fs.StringVar(&cfg.opts.timeFormat, "time-format", cfg.opts.timeFormat, "Header time `format`: "+strings.Join(timeFormats, ", ")+" (relative shows how long ago the last poll was)")
+	fs.BoolVar(&cfg.noColor, "no-color", false, "Draw without colors (also set by the NO_COLOR environment variable)")
+	addPolicyFlag(fs, cfg)
+	fs.StringVar(&cfg.tagsFile, "tags", "", "Label sessions from a JSON `file` of name pattern rules, shown in a Tag column")
+	fs.StringVar(&cfg.themeFile, "theme-file", "", "Load colors from a theme `file` of \"key = color\" lines")
+	addSourceFlags(fs, cfg)
+	addLogFlags(fs, cfg)
+	addAdminFlag(fs, cfg)
+
+	// One-shot actions from before subcommands existed
+	fs.BoolVar(&cfg.legacyOnce, "once", false, "Show buffer info once and exit")
+	fs.StringVar(&cfg.legacyExport, "export", "", "Export to a CSV `file` and exit (same as the export command)")
+	fs.StringVar(&cfg.legacyJSON, "json", "", "Export to a versioned JSON `file` and exit (same as export -format json)")
+	addJSONFlags(fs, cfg)
+	addClipFlag(fs, cfg)
+	fs.StringVar(&cfg.legacyText, "export-txt", "", "Export the rendered table as plain text to a `file` and exit (same as export -format txt)")
+	fs.StringVar(&cfg.serveAPI, "api", "", "Serve sessions as JSON at http://`addr`/api/sessions, headless (default addr: "+defaultAPIAddr+")")
+	fs.BoolVar(&cfg.legacyDiag, "diagnose", false, "Print diagnostic information for bug reports and exit")
+	addDiagnoseFlags(fs, cfg)
+	fs.StringVar(&cfg.pushGateway, "push-gateway", "", "Query once, push the metrics to a Prometheus Pushgateway at `url` and exit")
+	fs.StringVar(&cfg.pushJob, "push-job", cfg.pushJob, "`job` label for -push-gateway")
+	fs.StringVar(&cfg.pushInstance, "push-instance", "", "`instance` label for -push-gateway (default: computer name)")
+	fs.StringVar(&cfg.stopSession, "stop", "", "Stop the ETW `session` of this name and exit (needs Administrator)")
+	fs.StringVar(&cfg.session, "session", "", "Show only the ETW `session` of this name, queried with QueryTraceW, and exit")
+	fs.StringVar(&cfg.etlFile, "etl", "", "Show the session configuration a captured trace `file` was recorded with and exit")
+}
+
+func addDiagnoseFlags(fs *flag.FlagSet, cfg *cliConfig) {
+	fs.StringVar(&cfg.dumpRaw, "dump-raw", "", "Also hex-dump the raw EVENT_TRACE_PROPERTIES of the `session`, annotated with field offsets")
+}
+
+func addExportFlags(fs *flag.FlagSet, cfg *cliConfig) {
+	fs.StringVar(&cfg.format, "format", cfg.format, "Output `format`: csv, json or txt")
+	addJSONFlags(fs, cfg)
+	addClipFlag(fs, cfg)
+	addSourceFlags(fs, cfg)
+	addLogFlags(fs, cfg)
+	addAdminFlag(fs, cfg)
+}
+
+func addJSONFlags(fs *flag.FlagSet, cfg *cliConfig) {
+	fs.BoolVar(&cfg.rawJSON, "raw-json", false, "Write JSON as a bare session array without the version envelope")
+	fs.Var(&cfg.fields, "fields", "Write only these session keys in JSON (`name,events_lost,...`; default: all)")
+}
+
+func (cfg *cliConfig) jsonOptions() jsonOptions {
+	return jsonOptions{raw: cfg.rawJSON, fields: cfg.jsonFields}
+}
+
+func addClipFlag(fs *flag.FlagSet, cfg *cliConfig) {
+	fs.BoolVar(&cfg.clip, "clip", false, "Copy the export to the clipboard; without a file it is only copied (alone: the rendered table)")
+}
+
+func addRTLostFlag(fs *flag.FlagSet, cfg *cliConfig) {
+	fs.IntVar(&cfg.rtLost, "warn-rt-lost", cfg.rtLost, "Warn when a real-time session loses `N` buffers in one interval, or in total for check (0 disables)")
+}
+
+func addPolicyFlag(fs *flag.FlagSet, cfg *cliConfig) {
+	fs.StringVar(&cfg.policyFile, "policy", "", "Warn when sessions' buffer settings drift from the ranges in a JSON policy `file`")
+}
+
+func addCheckFlags(fs *flag.FlagSet, cfg *cliConfig) {
+	addPolicyFlag(fs, cfg)
+	addRTLostFlag(fs, cfg)
+	fs.BoolVar(&cfg.opts.checkLogFiles, "check-logfiles", false, "Also warn when file-backed sessions can't write their log file")
+	fs.Var(&cfg.expected, "expect", "Warn when any of these sessions is not running (`name1,name2`)")
+	addSourceFlags(fs, cfg)
+	addLogFlags(fs, cfg)
+	addAdminFlag(fs, cfg)
+}
+
+// Build a command's flag set with generated usage text
+func (c command) flagSet(cfg *cliConfig) *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	if c.flags != nil {
+		c.flags(fs, cfg)
+	}
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: ETWtop.exe %s\n\n%s\n", c.synopsis, c.summary)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintln(out)
+			fmt.Fprintln(out, "Options:")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// Parse the command line and run the chosen command, returning the exit code
+func runCLI(args []string) int {
+	name := "monitor"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name = strings.ToLower(args[0])
+		args = args[1:]
+	}
+	if name == "help" {
+		if len(args) > 0 {
+			if c, ok := findCommand(strings.ToLower(args[0])); ok {
+				fs := c.flagSet(newCLIConfig())
+				fs.SetOutput(os.Stdout)
+				fs.Usage()
+				return exitOK
+			}
+		}
+		showHelp()
+		return exitOK
+	}
+
+	c, ok := findCommand(name)
+	if !ok {
+		fmt.Printf("Unknown command: %s\n\n", name)
+		showHelp()
+		return exitError
+	}
+
+	cfg := newCLIConfig()
+	fs := c.flagSet(cfg)
+	if name == "monitor" {
+		// Bare -help shows the overview rather than just the monitor flags
+		fs.Usage = showHelp
+		args = expandOptionalValues(args)
+	}
+	fs.SetOutput(os.Stdout)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitError
+	}
+	cfg.explicit = make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { cfg.explicit[f.Name] = true })
+	if cfg.requireAdmin {
+		elevated, err := processElevated()
+		if err != nil {
+			fmt.Printf("Error: -require-admin could not determine elevation: %v\n", err)
+			return exitError
+		}
+		if !elevated {
+			fmt.Println("Error: not running elevated (-require-admin). Run from an Administrator prompt.")
+			return exitError
+		}
+	}
+	cfg.finalize()
+	if cfg.monitor.queryAPI == "single" && len(cfg.monitor.queryNames) == 0 {
+		fmt.Println("Error: -query-api single needs the sessions to query, named with -pin or -expect")
+		return exitError
+	}
+	changeNames := []string(cfg.changeFields)
+	if len(changeNames) == 0 {
+		changeNames = defaultChangeFields
+	}
+	changeFields, err := parseChangeFields(changeNames)
+	if err != nil {
+		fmt.Printf("Invalid -change-fields: %v\n", err)
+		return exitError
+	}
+	cfg.opts.changeFields = changeFields
+	if len(cfg.fields) > 0 {
+		fields, err := parseJSONFields(cfg.fields)
+		if err != nil {
+			fmt.Printf("Invalid -fields: %v\n", err)
+			return exitError
+		}
+		cfg.jsonFields = fields
+		cfg.opts.jsonFields = fields
+	}
+	// Unlike display settings, a policy that can't be loaded must not let
+	// check pass, so it ends the run
+	if cfg.policyFile != "" {
+		policy, err := loadPolicy(cfg.policyFile)
+		if err != nil {
+			fmt.Printf("Error loading policy: %v\n", err)
+			return exitError
+		}
+		cfg.opts.policy = policy
+	}
+
+	if cfg.opts.logFile != "" {
+		logFile, err := openActivityLog(cfg.opts.logFile, cfg.logFormat, cfg.logLevel)
+		if err != nil {
+			fatalf("Error opening log file: %v", err)
+		}
+		defer logFile.Close()
+	}
+
+	return c.run(cfg, fs.Args())
+}
+
+// Flags that used to be valid without a value. The flag package can't
+// express that, so the default is filled in before parsing.
+var optionalValueFlags = map[string]string{
+	"export":     defaultCSVFile,
+	"json":       defaultJSONFile,
+	"export-txt": defaultTextFile,
+	"api":        defaultAPIAddr,
+}
+
+func expandOptionalValues(args []string) []string {
+	var expanded []string
+	for i, arg := range args {
+		expanded = append(expanded, arg)
+		if arg == "--" {
+			return append(expanded, args[i+1:]...)
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		// A lone "-" is a value, the file name for stdout
+		value, ok := optionalValueFlags[strings.ToLower(strings.TrimLeft(arg, "-"))]
+		if ok && (i+1 == len(args) || strings.HasPrefix(args[i+1], "-") && args[i+1] != "-") {
+			expanded = append(expanded, value)
+		}
+	}
+	return expanded
+}
+
+// Check parsed values, falling back to defaults as the old parser did, and
+// copy the intermediate flag values into the monitor and its options
+func (cfg *cliConfig) finalize() {
+	defaults := newCLIConfig()
+	if cfg.opts.tailNew || cfg.opts.stream {
+		cfg.opts.headless = true
+	}
+	// Stdout carries the stream, so nothing else may be printed there
+	var out io.Writer = os.Stdout
+	if cfg.opts.stream {
+		quiet = true
+		out = os.Stderr
+	}
+	if cfg.opts.intervalSeconds <= 0 {
+		fmt.Fprintf(out, "Invalid interval '%d', using default: %d seconds\n", cfg.opts.intervalSeconds, defaults.opts.intervalSeconds)
+		cfg.opts.intervalSeconds = defaults.opts.intervalSeconds
+	}
+	if cfg.tagsFile != "" {
+		tags, err := loadTagRules(cfg.tagsFile)
+		if err != nil {
+			fmt.Fprintf(out, "Invalid tags file: %v, showing no tags\n", err)
+		}
+		cfg.opts.tags = tags
+	}
+	if cfg.themeFile != "" {
+		if err := loadThemeFile(cfg.themeFile); err != nil {
+			fmt.Fprintf(out, "Invalid theme file: %v, using default colors\n", err)
+		}
+	}
+	if cfg.opts.rateWindow < 1 || cfg.opts.rateWindow >= historySize {
+		fmt.Fprintf(out, "Invalid rate window '%d', using default: %d\n", cfg.opts.rateWindow, defaults.opts.rateWindow)
+		cfg.opts.rateWindow = defaults.opts.rateWindow
+	}
+	if cfg.opts.idlePolls < 1 || cfg.opts.idlePolls >= historySize {
+		fmt.Fprintf(out, "Invalid idle polls '%d', using default: %d\n", cfg.opts.idlePolls, defaults.opts.idlePolls)
+		cfg.opts.idlePolls = defaults.opts.idlePolls
+	}
+	if cfg.opts.depleteRate <= 0 {
+		fmt.Fprintf(out, "Invalid depletion rate '%g', using default: %.0f%%\n", cfg.opts.depleteRate, defaults.opts.depleteRate)
+		cfg.opts.depleteRate = defaults.opts.depleteRate
+	}
+	cfg.opts.gauge = strings.ToLower(cfg.opts.gauge)
+	if !slices.Contains(gaugeModes, cfg.opts.gauge) {
+		fmt.Fprintf(out, "Invalid gauge '%s', using default: numeric (valid: %s)\n", cfg.opts.gauge, strings.Join(gaugeModes, ", "))
+		cfg.opts.gauge = defaults.opts.gauge
+	}
+	if cfg.opts.rollup < 0 {
+		fmt.Fprintf(out, "Invalid rollup depth '%d', starting ungrouped\n", cfg.opts.rollup)
+		cfg.opts.rollup = 0
+	}
+	cfg.opts.detailMode = strings.ToLower(cfg.opts.detailMode)
+	if !slices.Contains(detailModes, cfg.opts.detailMode) {
+		fmt.Fprintf(out, "Invalid detail mode '%s', using default: snapshot (valid: %s)\n", cfg.opts.detailMode, strings.Join(detailModes, ", "))
+		cfg.opts.detailMode = defaults.opts.detailMode
+	}
+	cfg.opts.layout = strings.ToLower(cfg.opts.layout)
+	if !slices.Contains(layouts, cfg.opts.layout) {
+		fmt.Fprintf(out, "Invalid layout '%s', using default: top (valid: %s)\n", cfg.opts.layout, strings.Join(layouts, ", "))
+		cfg.opts.layout = defaults.opts.layout
+	}
+	cfg.opts.timeFormat = strings.ToLower(cfg.opts.timeFormat)
+	if !slices.Contains(timeFormats, cfg.opts.timeFormat) {
+		fmt.Fprintf(out, "Invalid time format '%s', using default: absolute (valid: %s)\n", cfg.opts.timeFormat, strings.Join(timeFormats, ", "))
+		cfg.opts.timeFormat = defaults.opts.timeFormat
+	}
+	cfg.opts.sortKey = strings.ToLower(cfg.opts.sortKey)
+	if cfg.opts.sortKey == "none" {
+		cfg.opts.sortKey = "index"
+	}
+	if !slices.Contains(sortKeys, cfg.opts.sortKey) {
+		fmt.Fprintf(out, "Invalid sort '%s', using default: name (valid: %s)\n", cfg.opts.sortKey, strings.Join(sortKeys, ", "))
+		cfg.opts.sortKey = defaults.opts.sortKey
+	}
+	if cfg.logMaxSize != "" {
+		if size, err := parseSize(cfg.logMaxSize); err == nil {
+			cfg.opts.logMaxSize = size
+		} else {
+			fmt.Fprintf(out, "Invalid log size '%s', rotation disabled\n", cfg.logMaxSize)
+		}
+	}
+	if cfg.opts.reconcileEvery < 0 {
+		fmt.Fprintf(out, "Invalid reconcile interval '%s', using default: %s\n", cfg.opts.reconcileEvery, defaults.opts.reconcileEvery)
+		cfg.opts.reconcileEvery = defaults.opts.reconcileEvery
+	}
+	if cfg.opts.flushInterval < 0 {
+		fmt.Fprintf(out, "Invalid flush interval '%s', writing every refresh\n", cfg.opts.flushInterval)
+		cfg.opts.flushInterval = 0
+	}
+	if cfg.opts.count < 0 {
+		fmt.Fprintf(out, "Invalid count '%d', running until quit\n", cfg.opts.count)
+		cfg.opts.count = 0
+	}
+	cfg.opts.logRotate = strings.ToLower(cfg.opts.logRotate)
+	if cfg.opts.logRotate != "" && !slices.Contains(logRotations, cfg.opts.logRotate) {
+		fmt.Fprintf(out, "Invalid log rotation '%s', rotating by size only\n", cfg.opts.logRotate)
+		cfg.opts.logRotate = ""
+	}
+	if cfg.opts.logMaxFiles < 0 {
+		fmt.Fprintf(out, "Invalid log file count '%d', keeping all rotated logs\n", cfg.opts.logMaxFiles)
+		cfg.opts.logMaxFiles = 0
+	}
+	if cfg.rtLost >= 0 && int64(cfg.rtLost) <= math.MaxUint32 {
+		cfg.opts.warnRTLost = uint32(cfg.rtLost)
+	} else {
+		fmt.Fprintf(out, "Invalid real-time loss threshold '%d', using default: %d\n", cfg.rtLost, defaults.opts.warnRTLost)
+	}
+	if cfg.cooldown >= 0 {
+		cfg.opts.onWarnCooldown = time.Duration(cfg.cooldown) * time.Second
+	} else {
+		fmt.Fprintf(out, "Invalid cooldown '%d', using default: %s\n", cfg.cooldown, defaults.opts.onWarnCooldown)
+	}
+	cfg.format = strings.ToLower(cfg.format)
+	cfg.logFormat = strings.ToLower(cfg.logFormat)
+	if !slices.Contains(logFormats, cfg.logFormat) {
+		fmt.Fprintf(out, "Invalid log format '%s', using default: text\n", cfg.logFormat)
+		cfg.logFormat = defaults.logFormat
+	}
+	cfg.logLevel = strings.ToLower(cfg.logLevel)
+	if _, ok := logLevels[cfg.logLevel]; !ok {
+		fmt.Fprintf(out, "Invalid log level '%s', using default: info\n", cfg.logLevel)
+		cfg.logLevel = defaults.logLevel
+	}
+
+	cfg.monitor.queryAPI = strings.ToLower(cfg.monitor.queryAPI)
+	if !slices.Contains(queryAPIs, cfg.monitor.queryAPI) {
+		fmt.Fprintf(out, "Invalid query API '%s', using default: all\n", cfg.monitor.queryAPI)
+		cfg.monitor.queryAPI = "all"
+	}
+
+	cfg.opts.pinned = cfg.pinned
+	cfg.opts.expected = cfg.expected
+	for _, name := range append(slices.Clone(cfg.pinned), cfg.expected...) {
+		if !slices.Contains(cfg.monitor.queryNames, name) {
+			cfg.monitor.queryNames = append(cfg.monitor.queryNames, name)
+		}
+	}
+	cfg.monitor.hosts = cfg.hosts
+	if cfg.anonymize {
+		cfg.monitor.anonymizer = newAnonymizer()
+	}
+	if cfg.computer != "" {
+		cfg.monitor.remotePath = resolveRemotePath(cfg.computer)
+	}
+}
+
+// Set by -quiet: banners and progress messages are left out so stdout
+// holds only what was asked for
+var quiet bool
+
+// Print a message that is only informational, unless -quiet
+func notef(format string, args ...any) {
+	if !quiet {
+		fmt.Printf(format, args...)
+	}
+}
+
+// Warn when the process token isn't elevated; a token whose elevation can't
+// be read, or -quiet, doesn't warn
+func warnIfNotAdmin() {
+	if !checkAdminPrivileges() {
+		notef("Warning: This tool requires administrator privileges to access ETW sessions.\n")
+		notef("Please run as Administrator for full functionality.\n\n")
+	}
+}
+
+func boolExit(ok bool) int {
+	if ok {
+		return exitOK
+	}
+	return exitError
+}
+
+func runMonitor(cfg *cliConfig, args []string) int {
+	if len(args) > 0 {
+		fmt.Printf("Unknown option: %s (see ETWtop.exe -help)\n", args[0])
+		return exitError
+	}
+
+	switch {
+	case cfg.legacyDiag || cfg.dumpRaw != "":
+		return boolExit(runDiagnostics(cfg.dumpRaw))
+	case cfg.stopSession != "":
+		return stopSession(cfg)
+	case cfg.session != "":
+		warnIfNotAdmin()
+		return showSession(cfg)
+	case cfg.pushGateway != "":
+		sessions, err := cfg.monitor.QueryAllSessions()
+		if err != nil {
+			fmt.Printf("Error querying sessions: %v\n", err)
+			return exitError
+		}
+		if err := pushMetrics(cfg.pushGateway, cfg.pushJob, cfg.pushInstance, sessions); err != nil {
+			activityLog.Error("push failed", "gateway", cfg.pushGateway, "error", err)
+			fmt.Printf("Error pushing metrics: %v\n", err)
+			return exitError
+		}
+		activityLog.Info("metrics pushed", "gateway", cfg.pushGateway, "sessions", len(sessions))
+		return exitOK
+	case cfg.etlFile != "":
+		if err := cfg.monitor.ShowETL(cfg.etlFile, cfg.opts); err != nil {
+			fmt.Printf("Error reading ETL file: %v\n", err)
+			return exitError
+		}
+		return exitOK
+	case cfg.serveAPI != "":
+		// Headless, so the terminal is free for the activity log
+		if cfg.opts.logFile == "" {
+			setActivityLog(os.Stderr, cfg.logFormat, cfg.logLevel)
+		}
+		if err := cfg.monitor.ServeAPI(cfg.serveAPI); err != nil {
+			fatalf("Error serving API: %v", err)
+		}
+		return exitOK
+	case cfg.legacyExport != "":
+		warnIfNotAdmin()
+		cfg.showOnceBeforeExport(cfg.legacyExport)
+		return exportSessions(cfg, "csv", cfg.legacyExport)
+	case cfg.legacyJSON != "":
+		warnIfNotAdmin()
+		cfg.showOnceBeforeExport(cfg.legacyJSON)
+		return exportSessions(cfg, "json", cfg.legacyJSON)
+	case cfg.legacyText != "":
+		warnIfNotAdmin()
+		cfg.showOnceBeforeExport(cfg.legacyText)
+		return exportSessions(cfg, "txt", cfg.legacyText)
+	case cfg.clip:
+		warnIfNotAdmin()
+		return exportSessions(cfg, "txt", "")
+	}
+
+	warnIfNotAdmin()
+	if !cfg.opts.headless {
+		applyTerminalDefaults(cfg)
+	}
+	if cfg.legacyOnce {
+		cfg.monitor.ShowOnce(cfg.opts)
+		return exitOK
+	}
+	if cfg.opts.headless {
+		return cfg.monitor.RunHeadless(cfg.opts)
+	}
+	cfg.monitor.StartMonitoring(cfg.opts)
+	return exitOK
+}
+
+// With -once as well as an export, show the table once before writing the
+// export, so one run gives both. Not when the export goes to stdout, where
+// the table would end up in the exported data.
+func (cfg *cliConfig) showOnceBeforeExport(filename string) {
+	if !cfg.legacyOnce || filename == "-" {
+		return
+	}
+	applyTerminalDefaults(cfg)
+	cfg.monitor.ShowOnce(cfg.opts)
+}
+
+// Stop the -stop session. Only an elevated process can control sessions,
+// so an unelevated one is told so up front rather than by a denied call.
+func stopSession(cfg *cliConfig) int {
+	if !checkAdminPrivileges() {
+		fmt.Println("Error: stopping a session needs Administrator rights. Run from an Administrator prompt.")
+		return exitError
+	}
+	if err := cfg.monitor.StopSession(cfg.stopSession); err != nil {
+		activityLog.Error("stop failed", "session", cfg.stopSession, "error", err)
+		if errors.Is(err, errStopDenied) {
+			fmt.Printf("Error: access denied stopping %s. Some system sessions are protected and can't be stopped even from an elevated prompt.\n", cfg.stopSession)
+		} else {
+			fmt.Printf("Error: %v\n", err)
+		}
+		return exitError
+	}
+	activityLog.Info("session stopped", "session", cfg.stopSession)
+	notef("Stopped session %s\n", cfg.stopSession)
+	return exitOK
+}
+
+// Print the -session session as the one-shot table shows it. A session that
+// isn't running is an error, so a script can tell it apart from an idle one.
+func showSession(cfg *cliConfig) int {
+	session, err := cfg.monitor.QuerySession(cfg.session)
+	if err != nil {
+		if errors.Is(err, errSessionNotFound) {
+			fmt.Printf("Error: no session named %s is running (ETWtop.exe -once lists them)\n", cfg.session)
+		} else {
+			fmt.Printf("Error querying session: %v\n", err)
+		}
+		return exitError
+	}
+	fmt.Print(cfg.monitor.textTable([]ETWSession{*session}, cfg.opts))
+	return exitOK
+}
+
+func runExport(cfg *cliConfig, args []string) int {
+	if len(args) > 1 {
+		fmt.Printf("Unexpected argument: %s\n", args[1])
+		return exitError
+	}
+	filename := ""
+	if len(args) == 1 {
+		filename = args[0]
+	}
+	warnIfNotAdmin()
+	return exportSessions(cfg, cfg.format, filename)
+}
+
+func exportSessions(cfg *cliConfig, format, filename string) int {
+	// With -clip the file is only written when one was named
+	toFile := filename != "" || !cfg.clip
+	// "-" writes to stdout for a pipe, so the banners stay out of it
+	if filename == "-" {
+		quiet = true
+	}
+	switch format {
+	case "csv":
+		if filename == "" && toFile {
+			filename = defaultCSVFile
+		}
+		notef("ETW Buffer Monitor - Exporting to CSV\n=====================================\n")
+	case "json":
+		if filename == "" && toFile {
+			filename = defaultJSONFile
+		}
+		notef("ETW Buffer Monitor - Exporting to JSON\n======================================\n")
+	case "txt":
+		if filename == "" && toFile {
+			filename = defaultTextFile
+		}
+		notef("ETW Buffer Monitor - Exporting to text\n======================================\n")
+	default:
+		fmt.Printf("Invalid format '%s' (valid: csv, json, txt)\n", format)
+		return exitError
+	}
+	if toFile && filename != "-" {
+		var err error
+		if filename, err = exportPath(filename, format); err != nil {
+			fmt.Printf("Invalid export file: %v\n", err)
+			return exitError
+		}
+	}
+
+	sessions, err := cfg.monitor.QueryAllSessions()
+	if err != nil {
+		fatalf("Error querying sessions: %v", err)
+	}
+	if cfg.clip {
+		var b strings.Builder
+		err = cfg.writeExport(&b, format, sessions)
+		if err == nil {
+			err = copyToClipboard(b.String())
+		}
+		if err != nil {
+			fmt.Printf("Error copying to the clipboard: %v\n", err)
+			return exitError
+		}
+		fmt.Fprintf(os.Stderr, "Copied %d bytes to the clipboard\n", b.Len())
+		activityLog.Info("export copied to clipboard", "format", format, "bytes", b.Len(), "sessions", len(sessions))
+		if !toFile {
+			return exitOK
+		}
+	}
+
+	if filename == "-" {
+		out := bufio.NewWriter(os.Stdout)
+		err = cfg.writeExport(out, format, sessions)
+		if err == nil {
+			err = out.Flush()
+		}
+		// A reader that stops early, like findstr /m, isn't an error
+		if err != nil && !isBrokenPipe(err) {
+			fmt.Fprintf(os.Stderr, "Error writing %s to stdout: %v\n", strings.ToUpper(format), err)
+			return exitError
+		}
+		return exitOK
+	}
+
+	switch format {
+	case "json":
+		err = cfg.monitor.exportJSON(sessions, filename, cfg.jsonOptions())
+	case "txt":
+		err = cfg.monitor.ExportToText(sessions, filename, cfg.opts)
+	default:
+		err = cfg.monitor.ExportToCSV(sessions, filename)
+	}
+	if err != nil {
+		fatalf("Error exporting to %s: %v", strings.ToUpper(format), err)
+	}
+	activityLog.Info("export written", "format", format, "file", filename, "sessions", len(sessions))
+	return exitOK
+}
+
+// Check an export file name before anything is queried, adding the format's
+// extension when it has none. A name starting with "-" is almost always the
+// next flag taken as the file name, as in "-export -interval 5". A missing
+// directory is created.
+func exportPath(filename, format string) (string, error) {
+	if strings.HasPrefix(filename, "-") {
+		return "", fmt.Errorf("%q looks like a flag; put the file name right after the option, or use ./%s for a file starting with -", filename, filename)
+	}
+	if filepath.Ext(filename) == "" {
+		filename += "." + format
+	}
+	dir := filepath.Dir(filename)
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		return "", fmt.Errorf("%s: %s is not a directory", filename, dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("%s: can't create the directory: %w", filename, err)
+	}
+	return filename, nil
+}
+
+// Write sessions in an export format to w, for the clipboard and stdout
+func (cfg *cliConfig) writeExport(w io.Writer, format string, sessions []ETWSession) error {
+	switch format {
+	case "json":
+		return writeJSON(w, sessions, cfg.jsonOptions())
+	case "txt":
+		_, err := io.WriteString(w, cfg.monitor.textTable(sessions, cfg.opts))
+		return err
+	}
+	return writeCSV(w, sessions)
+}
+
+func runCheck(cfg *cliConfig, args []string) int {
+	if len(args) > 0 {
+		fmt.Printf("Unexpected argument: %s\n", args[0])
+		return exitError
+	}
+	return cfg.monitor.Check(cfg.opts)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	CF_UNICODETEXT = 13
+	GMEM_MOVEABLE  = 0x0002
+)
+
+var (
+	user32               = syscall.NewLazyDLL("user32.dll")
+	procOpenClipboard    = user32.NewProc("OpenClipboard")
+	procCloseClipboard   = user32.NewProc("CloseClipboard")
+	procEmptyClipboard   = user32.NewProc("EmptyClipboard")
+	procSetClipboardData = user32.NewProc("SetClipboardData")
+	procGlobalAlloc      = kernel32.NewProc("GlobalAlloc")
+	procGlobalFree       = kernel32.NewProc("GlobalFree")
+	procGlobalLock       = kernel32.NewProc("GlobalLock")
+	procGlobalUnlock     = kernel32.NewProc("GlobalUnlock")
+	procRtlMoveMemory    = kernel32.NewProc("RtlMoveMemory")
+)
+
+// Replace the clipboard contents with text
+func copyToClipboard(text string) error {
+	data, err := syscall.UTF16FromString(text)
+	if err != nil {
+		return err
+	}
+
+	if ret, _, callErr := procOpenClipboard.Call(0); ret == 0 {
+		return fmt.Errorf("failed to open the clipboard: %v", callErr)
+	}
+	defer procCloseClipboard.Call()
+	if ret, _, callErr := procEmptyClipboard.Call(); ret == 0 {
+		return fmt.Errorf("failed to empty the clipboard: %v", callErr)
+	}
+
+	size := uintptr(len(data)) * unsafe.Sizeof(data[0])
+	handle, _, callErr := procGlobalAlloc.Call(GMEM_MOVEABLE, size)
+	if handle == 0 {
+		return fmt.Errorf("failed to allocate clipboard memory: %v", callErr)
+	}
+	ptr, _, callErr := procGlobalLock.Call(handle)
+	if ptr == 0 {
+		procGlobalFree.Call(handle)
+		return fmt.Errorf("failed to lock clipboard memory: %v", callErr)
+	}
+	procRtlMoveMemory.Call(ptr, uintptr(unsafe.Pointer(&data[0])), size)
+	procGlobalUnlock.Call(handle)
+
+	// The clipboard owns the memory once SetClipboardData succeeds
+	if ret, _, callErr := procSetClipboardData.Call(CF_UNICODETEXT, handle); ret == 0 {
+		procGlobalFree.Call(handle)
+		return fmt.Errorf("failed to set clipboard data: %v", callErr)
+	}
+	return nil
+}
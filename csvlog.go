@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rows buffered in memory between flushes with -flush-interval; a full
+// buffer is written out early
+const csvLogBufferSize = 256 << 10
+
+// Continuous CSV log: every poll is appended to one file, which is rotated to
+// a timestamped name once it grows past maxSize or its hour or day is over
+type csvLogger struct {
+	path          string
+	maxSize       int64         // Rotate when the file reaches this many bytes; 0 never rotates
+	rotateEvery   string        // "hourly" or "daily" to also rotate at each boundary; "" doesn't
+	period        time.Time     // Start of the hour or day the active file covers
+	maxFiles      int           // Rotated files to keep; 0 keeps all of them
+	flushInterval time.Duration // Write buffered rows to disk at most this often; 0 writes every poll
+	lastFlush     time.Time
+	file          *os.File
+	buffer        *bufio.Writer
+	writer        *csv.Writer
+}
+
+// Values accepted by -log-rotate
+var logRotations = []string{"hourly", "daily"}
+
+func newCSVLogger(path string, maxSize int64, rotateEvery string, maxFiles int, flushInterval time.Duration) (*csvLogger, error) {
+	l := &csvLogger{
+		path:          path,
+		maxSize:       maxSize,
+		rotateEvery:   rotateEvery,
+		maxFiles:      maxFiles,
+		flushInterval: flushInterval,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Open the active file for appending, writing the header if it is new or empty
+func (l *csvLogger) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat CSV log: %w", err)
+	}
+
+	l.file = file
+	l.buffer = bufio.NewWriterSize(file, csvLogBufferSize)
+	l.writer = csv.NewWriter(l.buffer)
+	l.lastFlush = time.Now()
+	// A file carried over from an earlier run keeps the window it was
+	// written in, so the first poll of a new window rotates it under that name
+	l.period = l.periodStart(time.Now())
+	if info.Size() > 0 {
+		l.period = l.periodStart(info.ModTime())
+	}
+	if info.Size() == 0 {
+		if err := l.writer.Write(csvHeader); err != nil {
+			return fmt.Errorf("failed to write CSV header: %w", err)
+		}
+		return l.flush()
+	}
+	return nil
+}
+
+// Write everything buffered to the file
+func (l *csvLogger) flush() error {
+	l.writer.Flush()
+	if err := l.writer.Error(); err != nil {
+		return err
+	}
+	l.lastFlush = time.Now()
+	return l.buffer.Flush()
+}
+
+// Start of the hour or day t falls in with -log-rotate, in local time
+func (l *csvLogger) periodStart(t time.Time) time.Time {
+	switch l.rotateEvery {
+	case "hourly":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case "daily":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+	return time.Time{}
+}
+
+// Append one poll's sessions, rotating first if the file is full or its
+// hour or day has passed
+func (l *csvLogger) Write(sessions []ETWSession) error {
+	if l.rotateEvery != "" {
+		if period := l.periodStart(time.Now()); !period.Equal(l.period) {
+			// Named after the window it covers, e.g. etw-20250101-140000.csv
+			if err := l.rotate(l.period); err != nil {
+				return err
+			}
+		}
+	}
+	if l.maxSize > 0 {
+		info, err := l.file.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to stat CSV log: %w", err)
+		}
+		if info.Size()+int64(l.buffer.Buffered()) >= l.maxSize {
+			if err := l.rotate(time.Now()); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, session := range sessions {
+		if err := l.writer.Write(csvRecord(session)); err != nil {
+			return fmt.Errorf("failed to write CSV record: %w", err)
+		}
+	}
+	// Move the rows into the buffer so Buffered() counts them for rotation
+	l.writer.Flush()
+	if err := l.writer.Error(); err != nil {
+		return err
+	}
+	if time.Since(l.lastFlush) >= l.flushInterval {
+		return l.flush()
+	}
+	return nil
+}
+
+// Rename the active file with a timestamp suffix, start a fresh one and
+// delete the oldest rotated files beyond the retention count
+func (l *csvLogger) rotate(stamp time.Time) error {
+	if err := l.flush(); err != nil {
+		l.file.Close()
+		return fmt.Errorf("failed to flush CSV log: %w", err)
+	}
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close CSV log: %w", err)
+	}
+
+	ext := filepath.Ext(l.path)
+	base := strings.TrimSuffix(l.path, ext)
+	rotated := base + "-" + stamp.Format("20060102-150405") + ext
+	for n := 1; fileExists(rotated); n++ {
+		rotated = fmt.Sprintf("%s-%s-%d%s", base, stamp.Format("20060102-150405"), n, ext)
+	}
+	if err := os.Rename(l.path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate CSV log: %w", err)
+	}
+
+	if err := l.open(); err != nil {
+		return err
+	}
+	return l.prune()
+}
+
+// Delete rotated files beyond maxFiles, oldest first. Timestamp suffixes sort
+// chronologically, so name order is age order.
+func (l *csvLogger) prune() error {
+	if l.maxFiles <= 0 {
+		return nil
+	}
+
+	ext := filepath.Ext(l.path)
+	rotated, err := filepath.Glob(strings.TrimSuffix(l.path, ext) + "-*" + ext)
+	if err != nil {
+		return fmt.Errorf("failed to list rotated CSV logs: %w", err)
+	}
+	sort.Strings(rotated)
+	for len(rotated) > l.maxFiles {
+		if err := os.Remove(rotated[0]); err != nil {
+			return fmt.Errorf("failed to delete old CSV log: %w", err)
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+func (l *csvLogger) Name() string {
+	return "CSV log " + l.path
+}
+
+// Flush any buffered rows and close the file
+func (l *csvLogger) Close() error {
+	if err := l.flush(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Parse a size such as "10MB", "512KB" or "1048576" into bytes (1 KB = 1024 bytes)
+func parseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
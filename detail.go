@@ -0,0 +1,47 @@
+package main
+
+import "time"
+
+// How the detail pane treats new queries, accepted by -detail:
+// snapshot holds the values from when the pane was opened (r refreshes them),
+// live updates them on every query and pause stops querying while it is open
+var detailModes = []string{"snapshot", "live", "pause"}
+
+// Open the detail pane on the selected row, or point it at a newly
+// selected row, capturing the values a snapshot pane shows
+func (m *model) openDetail() {
+	rows := m.displayRows()
+	if m.selected >= len(rows) || rows[m.selected].group != nil {
+		return
+	}
+	m.showDetail = true
+	m.detailRow = rows[m.selected]
+	m.detailAt = m.lastUpdate
+}
+
+// Keep the selection on the session the detail pane shows after a query
+// reordered the rows; a snapshot pane keeps its values even if it is gone
+func (m *model) followDetail() {
+	m.selectKey(rowKey(m.detailRow))
+}
+
+// Row the detail pane shows: the snapshot, or the selected row's current values
+func (m model) detailContent(rows []displayRow) (displayRow, bool) {
+	if m.detailMode == "snapshot" {
+		return m.detailRow, true
+	}
+	if m.selected < len(rows) {
+		return rows[m.selected], true
+	}
+	return displayRow{}, false
+}
+
+// Whether polling is held because the detail pane is open in pause mode
+func (m model) detailPaused() bool {
+	return m.showDetail && m.detailMode == "pause"
+}
+
+// Age of the detail pane's snapshot as shown in the pane
+func (m model) snapshotAge() time.Duration {
+	return m.lastUpdate.Sub(m.detailAt).Round(time.Second)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Windows version information filled in by RtlGetVersion
+type OSVERSIONINFOW struct {
+	OSVersionInfoSize uint32
+	MajorVersion      uint32
+	MinorVersion      uint32
+	BuildNumber       uint32
+	PlatformId        uint32
+	CSDVersion        [128]uint16
+}
+
+var (
+	ntdll             = syscall.NewLazyDLL("ntdll.dll")
+	procRtlGetVersion = ntdll.NewProc("RtlGetVersion")
+)
+
+// Windows version as reported by the kernel, unaffected by manifest compatibility shims
+func windowsVersion() string {
+	if err := procRtlGetVersion.Find(); err != nil {
+		return "unknown"
+	}
+
+	var info OSVERSIONINFOW
+	info.OSVersionInfoSize = uint32(unsafe.Sizeof(info))
+	procRtlGetVersion.Call(uintptr(unsafe.Pointer(&info)))
+
+	version := fmt.Sprintf("%d.%d.%d", info.MajorVersion, info.MinorVersion, info.BuildNumber)
+	if servicePack := syscall.UTF16ToString(info.CSDVersion[:]); servicePack != "" {
+		version += " " + servicePack
+	}
+	return version
+}
+
+// Print a copy-pasteable diagnostic block for bug reports, with the raw
+// properties of the session named dumpRaw when set. Returns whether querying
+// ETW sessions works (and the session to dump was found).
+func runDiagnostics(dumpRaw string) bool {
+	fmt.Println("ETW Buffer Monitor - Diagnostics")
+	fmt.Println("================================")
+	fmt.Printf("%-24s %s\n", "Windows version:", windowsVersion())
+	fmt.Printf("%-24s %s\n", "Architecture:", runtime.GOARCH)
+	if elevated, err := processElevated(); err != nil {
+		fmt.Printf("%-24s unknown: %v\n", "Elevated:", err)
+	} else {
+		fmt.Printf("%-24s %v (process token)\n", "Elevated:", elevated)
+	}
+	fmt.Printf("%-24s %s\n", "Terminal:", detectTerminal())
+
+	if err := procQueryAllTracesW.Find(); err != nil {
+		fmt.Printf("%-24s not resolvable: %v\n", "QueryAllTracesW:", err)
+		return false
+	}
+	fmt.Printf("%-24s resolvable\n", "QueryAllTracesW:")
+
+	// Same probe call QueryAllSessions starts with
+	var sessionCount uint32
+	ret, _, _ := procQueryAllTracesW.Call(
+		0,
+		0,
+		uintptr(unsafe.Pointer(&sessionCount)),
+	)
+	fmt.Printf("%-24s %d (expected %d, or %d with no sessions)\n", "Probe return code:", ret, ERROR_MORE_DATA, ERROR_SUCCESS)
+	fmt.Printf("%-24s %d\n", "Probe session count:", sessionCount)
+
+	monitor := NewETWBufferMonitor()
+	sessions, err := monitor.QueryAllSessions()
+	if err != nil {
+		fmt.Printf("%-24s failed: %v\n", "Session query:", err)
+		return false
+	}
+	fmt.Printf("%-24s %d session(s)\n", "Session query:", len(sessions))
+
+	if len(sessions) > 0 {
+		session := sessions[0]
+		fmt.Println()
+		fmt.Println("First session (raw fields):")
+		fmt.Printf("  %-22s %q\n", "Name", session.Name)
+		fmt.Printf("  %-22s %d\n", "BufferSize", session.BufferSize)
+		fmt.Printf("  %-22s %d\n", "MinimumBuffers", session.MinimumBuffers)
+		fmt.Printf("  %-22s %d\n", "MaximumBuffers", session.MaximumBuffers)
+		fmt.Printf("  %-22s %d\n", "NumberOfBuffers", session.NumberOfBuffers)
+		fmt.Printf("  %-22s %d\n", "FreeBuffers", session.FreeBuffers)
+		fmt.Printf("  %-22s %d\n", "BuffersWritten", session.BuffersWritten)
+		fmt.Printf("  %-22s %d\n", "EventsLost", session.EventsLost)
+		fmt.Printf("  %-22s %d\n", "RealTimeBuffersLost", session.RealTimeBuffersLost)
+		fmt.Printf("  %-22s 0x%08X\n", "LogFileMode", session.LogFileMode)
+		fmt.Printf("  %-22s %q\n", "LogFileName", session.LogFileName)
+	}
+	if dumpRaw != "" {
+		return dumpRawProperties(dumpRaw)
+	}
+	return true
+}
+
+// Print the raw EVENT_TRACE_PROPERTIES block of each local session named
+// name (case-insensitive) as a hex dump, followed by every field with its
+// offset and the value read there. Meant for chasing struct-layout and
+// offset differences between Windows builds and other ETW tools.
+// Returns whether a session was found.
+func dumpRawProperties(name string) bool {
+	buffer, count, err := queryAllTraces(&propertyBlocks{})
+	if err != nil {
+		fmt.Printf("Session query failed: %v\n", err)
+		return false
+	}
+
+	found := false
+	for i := uint32(0); i < count; i++ {
+		block := buffer[i*uint32(propertySize) : (i+1)*uint32(propertySize)]
+		props := (*EVENT_TRACE_PROPERTIES)(unsafe.Pointer(&block[0]))
+		loggerName := propertyString(props, props.LoggerNameOffset)
+		if !strings.EqualFold(loggerName, name) {
+			continue
+		}
+		found = true
+
+		fmt.Println()
+		fmt.Printf("EVENT_TRACE_PROPERTIES of %q (%d of %d bytes filled in by Windows, struct is %d)\n",
+			loggerName, props.Wnode.BufferSize, len(block), propertiesSize)
+		fmt.Println()
+		fmt.Print(hex.Dump(block))
+
+		fmt.Println()
+		fmt.Println("  Offset  Size  Field                      Value")
+		dumpFields(reflect.ValueOf(*props), 0, "")
+		fmt.Printf("  0x%04X        %-26s %q\n", props.LoggerNameOffset, "(logger name)", loggerName)
+		if props.LogFileNameOffset > 0 {
+			fmt.Printf("  0x%04X        %-26s %q\n", props.LogFileNameOffset, "(log file name)",
+				propertyString(props, props.LogFileNameOffset))
+		}
+	}
+	if !found {
+		fmt.Printf("No session named %q\n", name)
+	}
+	return found
+}
+
+// Print a struct's fields with their offsets, descending into nested structs
+func dumpFields(v reflect.Value, base uintptr, prefix string) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		value := v.Field(i)
+		offset := base + field.Offset
+		if value.Kind() == reflect.Struct {
+			dumpFields(value, offset, prefix+field.Name+".")
+			continue
+		}
+		var text string
+		switch value.Kind() {
+		case reflect.Array:
+			text = fmt.Sprintf("% X", value.Interface())
+		case reflect.Int32, reflect.Int64:
+			text = fmt.Sprintf("%d", value.Int())
+		default:
+			text = fmt.Sprintf("%d (0x%X)", value.Uint(), value.Uint())
+		}
+		fmt.Printf("  0x%04X  %4d  %-26s %s\n", offset, field.Type.Size(), prefix+field.Name, text)
+	}
+}
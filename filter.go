@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Whether a session name matches the filter. Matching is case-insensitive,
+// either on a substring or, in fuzzy mode, on the query's characters
+// appearing in order (so "secaud" matches "Security-Auditing").
+func (m model) matchesFilter(name string) bool {
+	if m.filter == "" {
+		return true
+	}
+	name, query := strings.ToLower(name), strings.ToLower(m.filter)
+	if !m.fuzzyFilter {
+		return strings.Contains(name, query)
+	}
+	for _, r := range query {
+		i := strings.IndexRune(name, r)
+		if i < 0 {
+			return false
+		}
+		name = name[i+utf8.RuneLen(r):]
+	}
+	return true
+}
+
+// Number of current sessions matching the filter
+func (m model) filterMatches() int {
+	matches := 0
+	for _, session := range m.sessions {
+		if m.matchesFilter(session.Name) {
+			matches++
+		}
+	}
+	return matches
+}
+
+// Handle a key while the filter bar is open. Every edit filters the sessions
+// already queried, so typing never waits on ETW, and the selection snaps to
+// the first match.
+func (m model) updateFilter(msg tea.KeyMsg) model {
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.filterInput = false
+		return m
+	case tea.KeyEsc:
+		m.filterInput = false
+		m.filter = ""
+	case tea.KeyCtrlF:
+		m.fuzzyFilter = !m.fuzzyFilter
+	case tea.KeyBackspace:
+		if _, size := utf8.DecodeLastRuneInString(m.filter); size > 0 {
+			m.filter = m.filter[:len(m.filter)-size]
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.filter += string(msg.Runes)
+	default:
+		return m
+	}
+	m.selected = 0
+	return m
+}
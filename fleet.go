@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Path of the JSON feed served by -api and read by -hosts
+const apiSessionsPath = "/api/sessions"
+
+// Serve the local sessions as the versioned JSON envelope until the process
+// is asked to stop, then let in-flight requests finish. Each request queries
+// ETW, so the feed is as fresh as the caller's poll.
+func (m *ETWBufferMonitor) ServeAPI(addr string) error {
+	var mu sync.Mutex
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiSessionsPath, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sessions, err := m.QueryAllSessions()
+		mu.Unlock()
+		if err != nil {
+			activityLog.Error("API query failed", "remote", r.RemoteAddr, "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		activityLog.Debug("API request", "remote", r.RemoteAddr, "sessions", len(sessions))
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(jsonPayload(sessions, jsonOptions{})); err != nil {
+			activityLog.Error("writing API response failed", "remote", r.RemoteAddr, "error", err)
+		}
+	})
+
+	ctx, stop := shutdownContext()
+	defer stop()
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		activityLog.Info("stopping on signal")
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	fmt.Printf("Serving ETW sessions at http://%s%s\n", addr, apiSessionsPath)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Convert a JSON session back into an ETWSession
+func (j sessionJSON) toSession() ETWSession {
+	timestamp, _ := time.Parse(time.RFC3339, j.Timestamp)
+	return ETWSession{
+		Name:                j.Name,
+		BufferSize:          j.BufferSizeKB,
+		MinimumBuffers:      j.MinimumBuffers,
+		MaximumBuffers:      j.MaximumBuffers,
+		NumberOfBuffers:     j.NumberOfBuffers,
+		FreeBuffers:         j.FreeBuffers,
+		BuffersWritten:      j.BuffersWritten,
+		EventsLost:          j.EventsLost,
+		RealTimeBuffersLost: j.RealTimeBuffersLost,
+		LogFileMode:         j.LogFileMode,
+		LogFileName:         j.LogFileName,
+		Guid:                j.Guid,
+		Index:               j.Index,
+		Timestamp:           timestamp,
+	}
+}
+
+// URL of a host's feed; "host:port" gets the default scheme and path
+func hostFeedURL(host string) string {
+	if strings.Contains(host, "://") {
+		return host
+	}
+	return "http://" + host + apiSessionsPath
+}
+
+// Fetch the sessions one -api instance is serving
+func fetchHostSessions(client *http.Client, host string) ([]ETWSession, error) {
+	resp, err := client.Get(hostFeedURL(host))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+
+	var envelope jsonEnvelope
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("invalid feed: %w", err)
+	}
+	if envelope.Version != jsonSchemaVersion {
+		return nil, fmt.Errorf("unsupported feed version %d (expected %d)", envelope.Version, jsonSchemaVersion)
+	}
+
+	sessions := make([]ETWSession, len(envelope.Sessions))
+	for i, entry := range envelope.Sessions {
+		sessions[i] = entry.toSession()
+		sessions[i].Host = host
+	}
+	return sessions, nil
+}
+
+// Poll every host concurrently. A host that fails keeps its last good
+// sessions, marked stale, so it doesn't vanish from the table.
+func (m *ETWBufferMonitor) queryHosts() []ETWSession {
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	var wg sync.WaitGroup
+	for _, host := range m.hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			sessions, err := fetchHostSessions(client, host)
+
+			m.hostMu.Lock()
+			defer m.hostMu.Unlock()
+			if err != nil && m.hostErrors[host] == nil {
+				activityLog.Warn("host not answering", "host", host, "error", err)
+			} else if err == nil && m.hostErrors[host] != nil {
+				activityLog.Info("host answering again", "host", host)
+			}
+			m.hostErrors[host] = err
+			if err == nil {
+				m.hostSessions[host] = sessions
+				return
+			}
+			for i := range m.hostSessions[host] {
+				m.hostSessions[host][i].Stale = true
+			}
+		}(host)
+	}
+	wg.Wait()
+
+	m.hostMu.Lock()
+	defer m.hostMu.Unlock()
+	var sessions []ETWSession
+	for _, host := range m.hosts {
+		sessions = append(sessions, m.hostSessions[host]...)
+	}
+	return sessions
+}
+
+// Copy of the last poll result per host: nil for reachable hosts
+func (m *ETWBufferMonitor) HostStatus() map[string]error {
+	m.hostMu.Lock()
+	defer m.hostMu.Unlock()
+	status := make(map[string]error, len(m.hostErrors))
+	for host, err := range m.hostErrors {
+		status[host] = err
+	}
+	return status
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Where -stream writes, replaceable so tests can stand in for a pipe
+var streamOutput io.Writer = os.Stdout
+
+// Context cancelled when the process is asked to stop: Ctrl+C or
+// Ctrl+Break, or the console being closed, the user logging off or the
+// system shutting down, which Go delivers as SIGTERM. Headless modes stop
+// through their normal cleanup instead of being killed mid-write.
+func shutdownContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// Poll on the interval without the TUI, feeding the CSV log and -on-warn
+// exactly as the TUI does and printing one line per query. Runs opts.count
+// queries, or until stopped when count is 0; the outputs are flushed either way.
+func (m *ETWBufferMonitor) RunHeadless(opts monitorOptions) int {
+	ctx, stop := shutdownContext()
+	defer stop()
+
+	state := m.monitorModel(opts)
+	defer state.closeOutputs()
+
+	// -stream output is buffered and flushed once per poll, so a reader sees
+	// each poll's lines together and promptly
+	stream := bufio.NewWriter(streamOutput)
+
+	status := exitOK
+	query := state.querySessionsCmd()
+poll:
+	for opts.count == 0 || state.refreshes < opts.count {
+		switch msg := query().(type) {
+		case sessionsMsg:
+			before := state.sessions
+			state.applySessions(msg)
+			if opts.stream {
+				err := writeNDJSON(stream, state.sessions, opts.jsonFields)
+				if err == nil {
+					err = stream.Flush()
+				}
+				if isBrokenPipe(err) {
+					// The reader is done, e.g. findstr /m found its match
+					activityLog.Info("stream reader went away, stopping")
+					break poll
+				}
+				if err != nil {
+					activityLog.Error("stream write failed", "error", err)
+					status = exitError
+					break poll
+				}
+				break
+			}
+			if opts.tailNew {
+				state.printLifecycle(before, state.refreshes == 1)
+				break
+			}
+			warnings := 0
+			for _, session := range state.sessions {
+				if len(state.sessionConditions(session)) > 0 {
+					warnings++
+				}
+			}
+			warnings += len(state.missingExpected())
+			fmt.Printf("%s  %d sessions  query %s  %d with warnings\n",
+				state.lastUpdate.Format("2006-01-02 15:04:05"), len(state.sessions),
+				state.queryDuration.Round(time.Millisecond), warnings)
+		case errMsg:
+			if opts.stream {
+				activityLog.Error("query failed", "error", msg)
+			} else {
+				fmt.Printf("%s  query failed: %v\n", time.Now().Format("2006-01-02 15:04:05"), msg)
+			}
+			status = exitError
+			// Count failed queries too, so -count always ends
+			state.refreshes++
+		}
+
+		if opts.count > 0 && state.refreshes >= opts.count {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			activityLog.Info("stopping on signal")
+			break poll
+		case <-time.After(state.refreshInterval):
+		}
+	}
+	state.finishRun(opts)
+	return status
+}
+
+// Print the sessions that appeared or disappeared since the previous query,
+// for -tail-new. The first query only reports how many sessions were
+// already running, so what follows is the lifecycle from then on.
+func (m model) printLifecycle(before []ETWSession, first bool) {
+	stamp := m.lastUpdate.Format("2006-01-02 15:04:05")
+	if first {
+		fmt.Printf("%s  watching %d running sessions\n", stamp, len(m.sessions))
+		return
+	}
+	label := func(session ETWSession) string {
+		if session.Host != "" {
+			return session.Host + "/" + session.Name
+		}
+		return session.Name
+	}
+	previous := make(map[string]bool, len(before))
+	for _, session := range before {
+		previous[session.Key()] = true
+	}
+	current := make(map[string]bool, len(m.sessions))
+	for _, session := range m.sessions {
+		current[session.Key()] = true
+		if previous[session.Key()] {
+			continue
+		}
+		mode := "buffered"
+		switch {
+		case session.IsRealTime() && session.IsFileBacked():
+			mode = "real-time and file"
+		case session.IsRealTime():
+			mode = "real-time"
+		case session.IsFileBacked():
+			mode = "file"
+		}
+		line := fmt.Sprintf("%s  + %s  %d KB buffers, min %d, max %d, %s", stamp, label(session),
+			session.BufferSize, session.MinimumBuffers, session.MaximumBuffers, mode)
+		if session.LogFileName != "" {
+			line += "  " + session.LogFileName
+		}
+		fmt.Println(line)
+	}
+	for _, session := range before {
+		if !current[session.Key()] {
+			fmt.Printf("%s  - %s\n", stamp, label(session))
+		}
+	}
+}
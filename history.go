@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Samples kept per session: a few minutes at the default 1s interval
+const historySize = 180
+
+// Samples considered when estimating time to saturation. All of them must
+// show utilization rising or flat, with an overall rise, for an estimate.
+const trendSamples = 5
+
+// One query's worth of a session's values
+type historySample struct {
+	at             time.Time
+	utilization    float64
+	eventsLost     uint32
+	buffersWritten uint32
+}
+
+// Fixed-size ring buffer of a session's recent samples
+type sessionHistory struct {
+	samples [historySize]historySample
+	start   int // Index of the oldest sample
+	count   int
+}
+
+func (h *sessionHistory) add(sample historySample) {
+	if h.count < historySize {
+		h.samples[(h.start+h.count)%historySize] = sample
+		h.count++
+		return
+	}
+	h.samples[h.start] = sample
+	h.start = (h.start + 1) % historySize
+}
+
+// Samples oldest first
+func (h *sessionHistory) all() []historySample {
+	samples := make([]historySample, h.count)
+	for i := range samples {
+		samples[i] = h.samples[(h.start+i)%historySize]
+	}
+	return samples
+}
+
+// Record the current query in each session's history and drop the history
+// of sessions that are gone
+func (m *model) recordHistory(sessions []ETWSession, at time.Time) {
+	present := make(map[string]bool, len(sessions))
+	for _, session := range sessions {
+		key := session.Key()
+		present[key] = true
+		h, ok := m.history[key]
+		if !ok {
+			h = &sessionHistory{}
+			m.history[key] = h
+		}
+		h.add(historySample{
+			at:             at,
+			utilization:    session.UtilizationPercent(),
+			eventsLost:     session.EventsLost,
+			buffersWritten: session.BuffersWritten,
+		})
+	}
+	for key := range m.history {
+		if !present[key] {
+			delete(m.history, key)
+		}
+	}
+}
+
+// Average per-interval increase of a counter over the last -rate-window
+// intervals of a session's history. Counter resets count as no increase.
+// ok is false until the history holds at least one interval.
+func (m model) windowedDelta(session ETWSession, counter func(historySample) uint32) (average float64, ok bool) {
+	h, exists := m.history[session.Key()]
+	if !exists || h.count < 2 {
+		return 0, false
+	}
+	samples := h.all()
+	samples = samples[max(0, len(samples)-m.rateWindow-1):]
+	var total uint64
+	for i := 1; i < len(samples); i++ {
+		if current, previous := counter(samples[i]), counter(samples[i-1]); current > previous {
+			total += uint64(current - previous)
+		}
+	}
+	return float64(total) / float64(len(samples)-1), true
+}
+
+// Per-second increase of a counter over the last -rate-window intervals of
+// a session's history, by the samples' own timestamps so a slow or adaptive
+// poll doesn't skew it. Counter resets count as no increase.
+func (m model) windowedRate(session ETWSession, counter func(historySample) uint32) (perSecond float64, ok bool) {
+	h, exists := m.history[session.Key()]
+	if !exists || h.count < 2 {
+		return 0, false
+	}
+	samples := h.all()
+	samples = samples[max(0, len(samples)-m.rateWindow-1):]
+	elapsed := samples[len(samples)-1].at.Sub(samples[0].at).Seconds()
+	if elapsed <= 0 {
+		return 0, false
+	}
+	var total uint64
+	for i := 1; i < len(samples); i++ {
+		if current, previous := counter(samples[i]), counter(samples[i-1]); current > previous {
+			total += uint64(current - previous)
+		}
+	}
+	return float64(total) / elapsed, true
+}
+
+// Events lost per minute over the last minute of a session's history, or
+// all of it while it covers less. Counter resets count as no loss. ok is
+// false until the history holds at least one interval.
+func (m model) lostPerMinute(session ETWSession) (rate float64, ok bool) {
+	h, exists := m.history[session.Key()]
+	if !exists || h.count < 2 {
+		return 0, false
+	}
+	samples := h.all()
+	last := samples[len(samples)-1]
+	first := len(samples) - 2
+	for first > 0 && last.at.Sub(samples[first-1].at) <= time.Minute {
+		first--
+	}
+	samples = samples[first:]
+	elapsed := last.at.Sub(samples[0].at).Minutes()
+	if elapsed <= 0 {
+		return 0, false
+	}
+	var lost uint64
+	for i := 1; i < len(samples); i++ {
+		if samples[i].eventsLost > samples[i-1].eventsLost {
+			lost += uint64(samples[i].eventsLost - samples[i-1].eventsLost)
+		}
+	}
+	return float64(lost) / elapsed, true
+}
+
+// Whether a session wrote no buffers over the last -idle-polls polls.
+// Sessions without that much history yet count as active.
+func (m model) isIdle(session ETWSession) bool {
+	h, exists := m.history[session.Key()]
+	if !exists || h.count <= m.idlePolls {
+		return false
+	}
+	samples := h.all()
+	samples = samples[len(samples)-m.idlePolls-1:]
+	for i := 1; i < len(samples); i++ {
+		if samples[i].buffersWritten != samples[i-1].buffersWritten {
+			return false
+		}
+	}
+	return true
+}
+
+// Levels of a one-line chart, lowest first
+var (
+	chartLevels      = []rune("▁▂▃▄▅▆▇█")
+	asciiChartLevels = []rune("_.-:=+*#")
+)
+
+// One-line chart of a session's events lost since the oldest sample in its
+// history, at most width cells wide. With more samples than cells, each cell
+// shows the last sample of its slice of the window. Empty without history.
+func (m model) lostChart(session ETWSession, width int) string {
+	h, exists := m.history[session.Key()]
+	if !exists || h.count < 2 || width <= 0 {
+		return ""
+	}
+	samples := h.all()
+	base := samples[0].eventsLost
+	cells := min(width, len(samples))
+	values := make([]uint32, cells)
+	var peak uint32
+	for i := range values {
+		sample := samples[(i+1)*len(samples)/cells-1]
+		if sample.eventsLost > base { // A restarted session's counter can drop below the base
+			values[i] = sample.eventsLost - base
+		}
+		peak = max(peak, values[i])
+	}
+
+	levels := chartLevels
+	if m.ascii {
+		levels = asciiChartLevels
+	}
+	chart := make([]rune, cells)
+	for i, value := range values {
+		level := 0
+		if peak > 0 {
+			level = int(uint64(value) * uint64(len(levels)-1) / uint64(peak))
+		}
+		chart[i] = levels[level]
+	}
+	return string(chart)
+}
+
+// Start a fresh measurement window: drop everything accumulated across
+// queries while keeping the current session list and interval deltas
+func (m *model) resetStatistics() {
+	m.history = make(map[string]*sessionHistory)
+	m.flagChanges = make(map[string]flagChange)
+	m.statsReset = time.Now()
+}
+
+// Estimated time until a session's buffers are full at its recent fill
+// rate. ok is false unless utilization has been climbing steadily.
+func (m model) timeToSaturation(session ETWSession) (eta time.Duration, ok bool) {
+	h, exists := m.history[session.Key()]
+	if !exists || h.count < 3 {
+		return 0, false
+	}
+	samples := h.all()
+	samples = samples[max(0, len(samples)-trendSamples):]
+	for i := 1; i < len(samples); i++ {
+		if samples[i].utilization < samples[i-1].utilization {
+			return 0, false
+		}
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	elapsed := last.at.Sub(first.at).Seconds()
+	if last.utilization <= first.utilization || elapsed <= 0 || last.utilization >= 100 {
+		return 0, false
+	}
+	perSecond := (last.utilization - first.utilization) / elapsed
+	return time.Duration((100 - last.utilization) / perSecond * float64(time.Second)), true
+}
+
+// Time to saturation as shown to the user, "-" when there is no estimate
+func (m model) saturationETA(session ETWSession) string {
+	eta, ok := m.timeToSaturation(session)
+	if !ok {
+		return "-"
+	}
+	if eta < time.Second {
+		return "<1s to full"
+	}
+	return fmt.Sprintf("~%s to full", eta.Round(time.Second))
+}
+
+// JSON form of the history export: the samples each session has in memory
+type historyExport struct {
+	Version     int                  `json:"version"`
+	GeneratedAt string               `json:"generated_at"`
+	Host        string               `json:"host"`
+	Sessions    []sessionHistoryJSON `json:"sessions"`
+}
+
+type sessionHistoryJSON struct {
+	Name    string              `json:"name"`
+	Guid    string              `json:"guid,omitempty"`
+	Samples []historySampleJSON `json:"samples"`
+}
+
+type historySampleJSON struct {
+	Timestamp          string  `json:"timestamp"`
+	UtilizationPercent float64 `json:"utilization_percent"`
+	EventsLost         uint32  `json:"events_lost"`
+	BuffersWritten     uint32  `json:"buffers_written"`
+}
+
+// Write the history of the sessions still present, in name order, up to
+// historySize samples each, oldest first
+func (m model) exportHistory(filename string) error {
+	host, _ := os.Hostname()
+	export := historyExport{
+		Version:     jsonSchemaVersion,
+		GeneratedAt: time.Now().Format(time.RFC3339),
+		Host:        host,
+		Sessions:    []sessionHistoryJSON{},
+	}
+	for _, session := range m.sessions {
+		h, ok := m.history[session.Key()]
+		if !ok {
+			continue
+		}
+		entry := sessionHistoryJSON{Name: session.Name, Guid: session.Guid}
+		for _, sample := range h.all() {
+			entry.Samples = append(entry.Samples, historySampleJSON{
+				Timestamp:          sample.at.Format(time.RFC3339),
+				UtilizationPercent: sample.utilization,
+				EventsLost:         sample.eventsLost,
+				BuffersWritten:     sample.buffersWritten,
+			})
+		}
+		export.Sessions = append(export.Sessions, entry)
+	}
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(export)
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Runs a user command when a session enters a warning state
+type warnHook struct {
+	command  []string      // Program and arguments, with %SESSION% and %CONDITION% placeholders
+	cooldown time.Duration // Minimum time between runs for the same session and condition
+	active   map[string]bool
+	lastRun  map[string]time.Time
+}
+
+func newWarnHook(command string, cooldown time.Duration) *warnHook {
+	return &warnHook{
+		command:  splitCommandLine(command),
+		cooldown: cooldown,
+		active:   make(map[string]bool),
+		lastRun:  make(map[string]time.Time),
+	}
+}
+
+// Fire the command for every condition that was not active on the previous
+// poll. Conditions that stay active don't fire again, and one that clears and
+// returns within the cooldown is suppressed.
+func (h *warnHook) Evaluate(conditions map[string][]string, names map[string]string) {
+	now := time.Now()
+	active := make(map[string]bool)
+	for key, sessionConditions := range conditions {
+		for _, condition := range sessionConditions {
+			id := key + "\x00" + condition
+			active[id] = true
+			if h.active[id] {
+				continue
+			}
+			if last, ran := h.lastRun[id]; ran && now.Sub(last) < h.cooldown {
+				activityLog.Info("on-warn suppressed by cooldown", "session", names[key], "condition", condition)
+				continue
+			}
+			h.lastRun[id] = now
+			go h.run(names[key], condition)
+		}
+	}
+	h.active = active
+}
+
+// Forget runs whose cooldown is over, which no longer suppress anything,
+// returning how many were dropped
+func (h *warnHook) prune(now time.Time) int {
+	pruned := 0
+	for id, last := range h.lastRun {
+		if now.Sub(last) >= h.cooldown {
+			delete(h.lastRun, id)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// Run the command with placeholders substituted. Each placeholder is replaced
+// inside a single argument and no shell is involved, so session names can't
+// inject extra commands.
+func (h *warnHook) run(session, condition string) {
+	if len(h.command) == 0 {
+		return
+	}
+
+	replacer := strings.NewReplacer("%SESSION%", session, "%CONDITION%", condition)
+	args := make([]string, len(h.command))
+	for i, arg := range h.command {
+		args[i] = replacer.Replace(arg)
+	}
+
+	start := time.Now()
+	err := exec.Command(args[0], args[1:]...).Run()
+	duration := time.Since(start).Round(time.Millisecond)
+	switch exitErr, ok := err.(*exec.ExitError); {
+	case err == nil:
+		activityLog.Info("on-warn finished", "session", session, "condition", condition, "exit_code", 0, "duration", duration)
+	case ok:
+		activityLog.Warn("on-warn finished", "session", session, "condition", condition, "exit_code", exitErr.ExitCode(), "duration", duration)
+	default:
+		activityLog.Error("on-warn failed to start", "session", session, "condition", condition, "error", err)
+	}
+}
+
+// Split a command line into arguments on whitespace, honoring double quotes
+func splitCommandLine(command string) []string {
+	var args []string
+	var current strings.Builder
+	inQuotes, hasArg := false, false
+	for _, r := range command {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if hasArg {
+				args = append(args, current.String())
+				current.Reset()
+				hasArg = false
+			}
+		default:
+			current.WriteRune(r)
+			hasArg = true
+		}
+	}
+	if hasArg {
+		args = append(args, current.String())
+	}
+	return args
+}
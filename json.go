@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Version of the JSON export layout. Adding fields keeps the version;
+// removing, renaming or changing the meaning of a field increments it.
+const jsonSchemaVersion = 1
+
+// Versioned wrapper around exported sessions
+type jsonEnvelope struct {
+	Version     int           `json:"version"`
+	GeneratedAt string        `json:"generated_at"`
+	Host        string        `json:"host"`
+	Sessions    []sessionJSON `json:"sessions"`
+}
+
+// JSON form of a session, including the calculated properties
+type sessionJSON struct {
+	Timestamp           string  `json:"timestamp"`
+	Name                string  `json:"name"`
+	BufferSizeKB        uint32  `json:"buffer_size_kb"`
+	MinimumBuffers      uint32  `json:"minimum_buffers"`
+	MaximumBuffers      uint32  `json:"maximum_buffers"`
+	NumberOfBuffers     uint32  `json:"number_of_buffers"`
+	FreeBuffers         uint32  `json:"free_buffers"`
+	BuffersWritten      uint32  `json:"buffers_written"`
+	EventsLost          uint32  `json:"events_lost"`
+	RealTimeBuffersLost uint32  `json:"real_time_buffers_lost"`
+	LogFileMode         uint32  `json:"log_file_mode"`
+	UtilizationPercent  float64 `json:"utilization_percent"`
+	TotalMemoryMB       float64 `json:"total_memory_mb"`
+	LogFileName         string  `json:"log_file_name"`
+	Guid                string  `json:"guid,omitempty"`
+	Index               int     `json:"index"`
+}
+
+func newSessionJSON(session ETWSession) sessionJSON {
+	return sessionJSON{
+		Timestamp:           session.Timestamp.Format(time.RFC3339),
+		Name:                session.Name,
+		BufferSizeKB:        session.BufferSize,
+		MinimumBuffers:      session.MinimumBuffers,
+		MaximumBuffers:      session.MaximumBuffers,
+		NumberOfBuffers:     session.NumberOfBuffers,
+		FreeBuffers:         session.FreeBuffers,
+		BuffersWritten:      session.BuffersWritten,
+		EventsLost:          session.EventsLost,
+		RealTimeBuffersLost: session.RealTimeBuffersLost,
+		LogFileMode:         session.LogFileMode,
+		UtilizationPercent:  session.UtilizationPercent(),
+		TotalMemoryMB:       session.TotalMemoryMB(),
+		LogFileName:         session.LogFileName,
+		Guid:                session.Guid,
+		Index:               session.Index,
+	}
+}
+
+// How sessions are written as JSON
+type jsonOptions struct {
+	raw    bool  // Bare session array without the version envelope
+	fields []int // sessionJSON field indices to keep, from -fields; nil keeps all
+}
+
+// JSON key of a sessionJSON field
+func jsonKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return key
+}
+
+// Resolve -fields names to sessionJSON field indices. Names match the JSON
+// key (events_lost) or the field name (EventsLost), ignoring case.
+func parseJSONFields(names []string) ([]int, error) {
+	t := reflect.TypeOf(sessionJSON{})
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		keys = append(keys, jsonKey(t.Field(i)))
+	}
+
+	var indices []int
+	for _, name := range names {
+		found := false
+		for i := 0; i < t.NumField(); i++ {
+			if strings.EqualFold(name, t.Field(i).Name) || strings.EqualFold(name, jsonKey(t.Field(i))) {
+				indices = append(indices, i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown field %q (valid: %s)", name, strings.Join(keys, ", "))
+		}
+	}
+	return indices, nil
+}
+
+// A session written with only the chosen fields, in the struct's order
+type partialSessionJSON struct {
+	entry   sessionJSON
+	fields  []int
+	version int // Written first when set, as on -stream lines
+}
+
+func (p partialSessionJSON) MarshalJSON() ([]byte, error) {
+	v := reflect.ValueOf(p.entry)
+	var b bytes.Buffer
+	b.WriteByte('{')
+	if p.version != 0 {
+		fmt.Fprintf(&b, `"version":%d`, p.version)
+	}
+	for i := 0; i < v.NumField(); i++ {
+		if !containsIndex(p.fields, i) {
+			continue
+		}
+		value, err := json.Marshal(v.Field(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%q:%s", jsonKey(v.Type().Field(i)), value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+func containsIndex(indices []int, i int) bool {
+	for _, index := range indices {
+		if index == i {
+			return true
+		}
+	}
+	return false
+}
+
+// Build the value to encode: the versioned envelope, or a bare session array
+// when raw is set for consumers of the original layout
+func jsonPayload(sessions []ETWSession, opts jsonOptions) any {
+	entries := make([]sessionJSON, len(sessions))
+	for i, session := range sessions {
+		entries[i] = newSessionJSON(session)
+	}
+
+	host, _ := os.Hostname()
+	envelope := jsonEnvelope{
+		Version:     jsonSchemaVersion,
+		GeneratedAt: time.Now().Format(time.RFC3339),
+		Host:        host,
+		Sessions:    entries,
+	}
+	if opts.fields == nil {
+		if opts.raw {
+			return entries
+		}
+		return envelope
+	}
+
+	partial := make([]partialSessionJSON, len(entries))
+	for i, entry := range entries {
+		partial[i] = partialSessionJSON{entry: entry, fields: opts.fields}
+	}
+	if opts.raw {
+		return partial
+	}
+	// The outer Sessions field takes the place of the envelope's
+	return struct {
+		jsonEnvelope
+		Sessions []partialSessionJSON `json:"sessions"`
+	}{envelope, partial}
+}
+
+// Write sessions as indented JSON, in the envelope unless raw is set
+func writeJSON(w io.Writer, sessions []ETWSession, opts jsonOptions) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(jsonPayload(sessions, opts)); err != nil {
+		return fmt.Errorf("failed to write JSON: %w", err)
+	}
+	return nil
+}
+
+// A -stream line: a session with the layout version, since a stream has no
+// envelope to carry it
+type streamSessionJSON struct {
+	Version int `json:"version"`
+	sessionJSON
+}
+
+// Write sessions as newline-delimited JSON, one compact session object per
+// line, each starting with the layout version and trimmed to fields when set
+func writeNDJSON(w io.Writer, sessions []ETWSession, fields []int) error {
+	encoder := json.NewEncoder(w)
+	for _, session := range sessions {
+		var entry any = streamSessionJSON{jsonSchemaVersion, newSessionJSON(session)}
+		if fields != nil {
+			entry = partialSessionJSON{newSessionJSON(session), fields, jsonSchemaVersion}
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Export sessions to JSON in the versioned envelope, with every field
+func (m *ETWBufferMonitor) ExportToJSON(sessions []ETWSession, filename string) error {
+	return m.exportJSON(sessions, filename, jsonOptions{})
+}
+
+// Export sessions to JSON as -raw-json and -fields ask
+func (m *ETWBufferMonitor) exportJSON(sessions []ETWSession, filename string, opts jsonOptions) error {
+	err := writeFileAtomic(filename, func(w io.Writer) error {
+		return writeJSON(w, sessions, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	notef("Buffer statistics exported to: %s\n", filename)
+	return nil
+}
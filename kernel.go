@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	KERNEL_LOGGER_NAME = "NT Kernel Logger"
+
+	// GUID of the kernel logger, as formatted by formatGUID
+	SystemTraceControlGuid = "{9E814AAD-3204-11D2-9A82-006008A86939}"
+
+	// LogFileMode bit of the system loggers that, like the kernel logger,
+	// take EnableFlags (Windows 8 and later)
+	EVENT_TRACE_SYSTEM_LOGGER_MODE = 0x02000000
+)
+
+// Kernel event classes selected by EnableFlags, in bit order
+var kernelEnableFlags = []struct {
+	flag uint32
+	name string
+}{
+	{0x00000001, "Process"},
+	{0x00000002, "Thread"},
+	{0x00000004, "ImageLoad"},
+	{0x00000008, "ProcessCounters"},
+	{0x00000010, "ContextSwitch"},
+	{0x00000020, "DPC"},
+	{0x00000040, "Interrupt"},
+	{0x00000080, "SystemCall"},
+	{0x00000100, "DiskIO"},
+	{0x00000200, "DiskFileIO"},
+	{0x00000400, "DiskIOInit"},
+	{0x00000800, "Dispatcher"},
+	{0x00001000, "PageFaults"},
+	{0x00002000, "HardFaults"},
+	{0x00004000, "VirtualAlloc"},
+	{0x00008000, "VAMap"},
+	{0x00010000, "NetworkTCPIP"},
+	{0x00020000, "Registry"},
+	{0x00040000, "DbgPrint"},
+	{0x00080000, "Job"},
+	{0x00100000, "ALPC"},
+	{0x00200000, "SplitIO"},
+	{0x00400000, "DebugEvents"},
+	{0x00800000, "Driver"},
+	{0x01000000, "Profile"},
+	{0x02000000, "FileIO"},
+	{0x04000000, "FileIOInit"},
+	{0x10000000, "NoSysConfig"},
+	{0x20000000, "EnableReserve"},
+	{0x40000000, "ForwardWMI"},
+	{0x80000000, "Extension"},
+}
+
+// Whether a session's EnableFlags select kernel event classes: the NT Kernel
+// Logger and the system loggers. For other sessions the value is opaque.
+func (s *ETWSession) IsKernelLogger() bool {
+	return s.Name == KERNEL_LOGGER_NAME ||
+		strings.EqualFold(s.Guid, SystemTraceControlGuid) ||
+		s.LogFileMode&EVENT_TRACE_SYSTEM_LOGGER_MODE != 0
+}
+
+// EnableFlags as shown in the detail pane: the kernel event class names
+// for kernel loggers, the raw value otherwise
+func (s *ETWSession) enableFlagsText() string {
+	raw := fmt.Sprintf("0x%08X", s.EnableFlags)
+	if !s.IsKernelLogger() || s.EnableFlags == 0 {
+		return raw
+	}
+
+	var names []string
+	remaining := s.EnableFlags
+	for _, f := range kernelEnableFlags {
+		if s.EnableFlags&f.flag != 0 {
+			names = append(names, f.name)
+			remaining &^= f.flag
+		}
+	}
+	if remaining != 0 {
+		names = append(names, fmt.Sprintf("0x%08X", remaining))
+	}
+	return raw + " (" + strings.Join(names, ", ") + ")"
+}
+
+// A session's enable flags changing between queries: someone reconfigured
+// its tracing while it ran
+type flagChange struct {
+	before, after uint32
+	at            time.Time
+}
+
+// Remember sessions whose enable flags changed in the latest query, until
+// statistics are reset, and log each change
+func (m *model) recordFlagChanges() {
+	for _, session := range m.sessions {
+		if !m.flagsChangedNow(session) {
+			continue
+		}
+		previous := m.previousSessions[session.Key()]
+		m.flagChanges[session.Key()] = flagChange{before: previous.EnableFlags, after: session.EnableFlags, at: m.lastUpdate}
+		activityLog.Warn("enable flags changed", "session", session.Key(),
+			"before", fmt.Sprintf("0x%08X", previous.EnableFlags), "after", fmt.Sprintf("0x%08X", session.EnableFlags))
+	}
+}
+
+// Whether a session's enable flags differ from the previous query
+func (m model) flagsChangedNow(session ETWSession) bool {
+	previous, existed := m.previousSessions[session.Key()]
+	return existed && previous.EnableFlags != session.EnableFlags
+}
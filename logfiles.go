@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Free space below which a log file's volume is reported as low
+const lowDiskSpaceBytes = 1 << 30
+
+var (
+	kernel32                = syscall.NewLazyDLL("kernel32.dll")
+	procGetDiskFreeSpaceExW = kernel32.NewProc("GetDiskFreeSpaceExW")
+)
+
+// Check whether a file-backed session can keep writing its log file.
+// Returns a short description of the problem, or "" when none was found.
+func checkLogFile(session ETWSession) string {
+	if !session.IsFileBacked() || !filepath.IsAbs(session.LogFileName) {
+		return ""
+	}
+
+	dir := filepath.Dir(session.LogFileName)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "log directory missing"
+	}
+
+	// Names with a %d placeholder (new-file mode) don't exist under that literal name
+	if !strings.Contains(session.LogFileName, "%") {
+		info, err := os.Stat(session.LogFileName)
+		if err != nil {
+			return "log file missing"
+		}
+		if info.Mode().Perm()&0200 == 0 {
+			return "log file is read-only"
+		}
+	}
+
+	probe, err := os.CreateTemp(dir, ".etwtop-*")
+	if err != nil {
+		return "log directory not writable"
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	if free, ok := diskFreeBytes(dir); ok && free < lowDiskSpaceBytes {
+		return fmt.Sprintf("low disk space (%d MB free)", free>>20)
+	}
+	return ""
+}
+
+// Bytes available to the caller on the volume holding dir
+func diskFreeBytes(dir string) (uint64, bool) {
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, false
+	}
+
+	var freeBytes uint64
+	ret, _, _ := procGetDiskFreeSpaceExW.Call(
+		uintptr(unsafe.Pointer(path)),
+		uintptr(unsafe.Pointer(&freeBytes)),
+		0,
+		0,
+	)
+	return freeBytes, ret != 0
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+)
+
+// Log of the tool's own activity: polls, retries, exports, hook runs and
+// errors. Discarded unless -logfile is given, since the TUI owns the
+// terminal; headless runs without -logfile log to stderr.
+var activityLog = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// Log formats and levels accepted by -log-format and -log-level
+var (
+	logFormats = []string{"text", "json"}
+	logLevels  = map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"info":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	}
+)
+
+// Point the activity log at w in the given format, logging at level and above
+func setActivityLog(w io.Writer, format, level string) {
+	options := &slog.HandlerOptions{Level: logLevels[level]}
+	if format == "json" {
+		activityLog = slog.New(slog.NewJSONHandler(w, options))
+	} else {
+		activityLog = slog.New(slog.NewTextHandler(w, options))
+	}
+}
+
+// Record a fatal error in the activity log, then report it and exit
+func fatalf(format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	activityLog.Error(message)
+	log.Fatal(message)
+}
+
+// Open the -logfile for appending and make it the activity log's destination
+func openActivityLog(path, format, level string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	setActivityLog(file, format, level)
+	return file, nil
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"cmp"
 	"encoding/csv"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 	"unicode/utf16"
@@ -15,13 +19,60 @@ import (
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
 )
 
+// Adaptive polling never backs off beyond this multiple of the base interval
+const maxAdaptiveMultiplier = 16
+
+// QueryAllTracesW calls made when sessions keep starting between the count
+// probe and the data call
+const maxQueryAttempts = 3
+
 const (
-	ERROR_SUCCESS          = 0
-	ERROR_MORE_DATA        = 234
-	MAX_SESSION_NAME_LEN   = 1024
-	WNODE_FLAG_TRACED_GUID = 0x00020000
+	ERROR_SUCCESS                = 0
+	ERROR_ACCESS_DENIED          = 5
+	ERROR_MORE_DATA              = 234
+	ERROR_WMI_INSTANCE_NOT_FOUND = 4201
+	MAX_SESSION_NAME_LEN         = 1024
+	WNODE_FLAG_TRACED_GUID       = 0x00020000
+
+	// LogFileMode bits decoded by LogFileModeString, besides the ones
+	// below that the monitor itself tests
+	EVENT_TRACE_FILE_MODE_APPEND           = 0x00000004
+	EVENT_TRACE_FILE_MODE_PREALLOCATE      = 0x00000020
+	EVENT_TRACE_NONSTOPPABLE_MODE          = 0x00000040
+	EVENT_TRACE_BUFFERING_MODE             = 0x00000400
+	EVENT_TRACE_ADD_HEADER_MODE            = 0x00001000
+	EVENT_TRACE_USE_KBYTES_FOR_SIZE        = 0x00002000
+	EVENT_TRACE_USE_GLOBAL_SEQUENCE        = 0x00004000
+	EVENT_TRACE_USE_LOCAL_SEQUENCE         = 0x00008000
+	EVENT_TRACE_RELOG_MODE                 = 0x00010000
+	EVENT_TRACE_STOP_ON_HYBRID_SHUTDOWN    = 0x00400000
+	EVENT_TRACE_PERSIST_ON_HYBRID_SHUTDOWN = 0x00800000
+	EVENT_TRACE_USE_PAGED_MEMORY           = 0x01000000
+	EVENT_TRACE_INDEPENDENT_SESSION_MODE   = 0x08000000
+	EVENT_TRACE_NO_PER_PROCESSOR_BUFFERING = 0x10000000
+	EVENT_TRACE_ADDTO_TRIAGE_DUMP          = 0x80000000
+	EVENT_TRACE_CONTROL_STOP               = 1
+
+	// LogFileMode bits used to tell file-backed from real-time sessions
+	EVENT_TRACE_FILE_MODE_SEQUENTIAL = 0x00000001
+	EVENT_TRACE_FILE_MODE_CIRCULAR   = 0x00000002
+	EVENT_TRACE_FILE_MODE_NEWFILE    = 0x00000008
+	EVENT_TRACE_REAL_TIME_MODE       = 0x00000100
+
+	// LogFileMode bits of private loggers, whose buffers live in the
+	// process that owns the session
+	EVENT_TRACE_PRIVATE_LOGGER_MODE = 0x00000800
+	EVENT_TRACE_PRIVATE_IN_PROC     = 0x00020000
+
+	// LogFileMode bit of secure loggers, which Windows may answer with
+	// zeroed statistics unless the caller holds extra privileges
+	EVENT_TRACE_SECURE_MODE = 0x00000080
+
+	// Console code page of UTF-8
+	CP_UTF8 = 65001
 )
 
 // Windows API structures
@@ -68,39 +119,231 @@ type ETWSession struct {
 	EventsLost          uint32
 	RealTimeBuffersLost uint32
 	LogFileMode         uint32
+	EnableFlags         uint32 // Kernel event classes for kernel loggers; opaque otherwise
+	ClockType           uint32 // Timestamp clock from WNODE_HEADER.ClientContext; see clockTypeName
+	LoggerThreadId      uint32 // Thread flushing the session's buffers; 0 when there is none
 	LogFileName         string
+	Guid                string // Session GUID; empty when Windows reports none
+	Instance            int    // Position among sessions sharing Name and Guid
+	Index               int    // Position in the array QueryAllTracesW returned, before sorting
+	Host                string // Source host with -hosts; empty for the local machine
+	realName            string // Name before -anonymize replaced it; "" when it didn't
+	Stale               bool   // Last known values from a host that stopped answering
 	Timestamp           time.Time
 }
 
+// Name that -pin, -expect, -policy and -tags match: the session's real
+// name, also when -anonymize shows a pseudonym in its place
+func (s *ETWSession) matchName() string {
+	if s.realName != "" {
+		return s.realName
+	}
+	return s.Name
+}
+
+// Identity used to track a session across queries. Names can collide, so the
+// GUID and the position among otherwise identical sessions are included.
+func (s *ETWSession) Key() string {
+	key := s.Name
+	if s.Host != "" {
+		key = s.Host + "/" + key
+	}
+	if s.Guid != "" {
+		key += "|" + s.Guid
+	}
+	if s.Instance > 0 {
+		key += "#" + strconv.Itoa(s.Instance)
+	}
+	return key
+}
+
+// Number sessions that share a host, name and GUID so each gets a distinct Key.
+// Sessions must already be sorted so duplicates are adjacent.
+func assignInstances(sessions []ETWSession) {
+	for i := 1; i < len(sessions); i++ {
+		if sessions[i].Host == sessions[i-1].Host && sessions[i].Name == sessions[i-1].Name && sessions[i].Guid == sessions[i-1].Guid {
+			sessions[i].Instance = sessions[i-1].Instance + 1
+		}
+	}
+}
+
+// Name of a session's timestamp clock as encoded in ClientContext, with the
+// raw value for unknown ones
+func clockTypeName(clock uint32) string {
+	switch clock {
+	case 1:
+		return "QPC (query performance counter)"
+	case 2:
+		return "System time"
+	case 3:
+		return "CPU cycle counter"
+	}
+	return fmt.Sprintf("unknown (%d)", clock)
+}
+
+// Format a GUID in registry form, or return "" for the all-zero GUID
+func formatGUID(b [16]byte) string {
+	if b == [16]byte{} {
+		return ""
+	}
+	return fmt.Sprintf("{%08X-%04X-%04X-%02X%02X-%02X%02X%02X%02X%02X%02X}",
+		uint32(b[0])|uint32(b[1])<<8|uint32(b[2])<<16|uint32(b[3])<<24,
+		uint16(b[4])|uint16(b[5])<<8,
+		uint16(b[6])|uint16(b[7])<<8,
+		b[8], b[9], b[10], b[11], b[12], b[13], b[14], b[15])
+}
+
 // Calculated properties
 func (s *ETWSession) UtilizationPercent() float64 {
-	if s.NumberOfBuffers == 0 {
+	if s.NumberOfBuffers == 0 || s.anomalous() {
 		return 0.0
 	}
 	return float64(s.NumberOfBuffers-s.FreeBuffers) / float64(s.NumberOfBuffers) * 100.0
 }
 
+// Whether Windows reported more free buffers than allocated ones, which
+// happens transiently while a session grows or shrinks its buffer pool
+func (s *ETWSession) anomalous() bool {
+	return s.FreeBuffers > s.NumberOfBuffers
+}
+
 func (s *ETWSession) TotalMemoryMB() float64 {
 	return float64(s.NumberOfBuffers*s.BufferSize) / 1024.0
 }
 
+// Format a memory size given in MB with a unit that keeps it readable:
+// KB under 1 MB, GB from 1024 MB, always one decimal place. Values that
+// would round up to 1024.0 move to the next unit.
+func formatMemory(mb float64) string {
+	switch {
+	case mb*1024 < 1023.95:
+		return fmt.Sprintf("%.1f KB", mb*1024)
+	case mb >= 1023.95:
+		return fmt.Sprintf("%.1f GB", mb/1024)
+	default:
+		return fmt.Sprintf("%.1f MB", mb)
+	}
+}
+
+// Whether the session reports no buffers, as it does just after starting.
+// Private loggers often report none for as long as they run, so they are
+// not treated as initializing.
+func (s *ETWSession) initializing() bool {
+	return s.NumberOfBuffers == 0 && !s.IsPrivateLogger() && !s.restricted()
+}
+
+// Whether a secure logger's statistics were withheld: it reports no buffers
+// and no activity, which would otherwise read as an idle, healthy session
+func (s *ETWSession) restricted() bool {
+	return s.LogFileMode&EVENT_TRACE_SECURE_MODE != 0 &&
+		s.NumberOfBuffers == 0 && s.BuffersWritten == 0 && s.EventsLost == 0
+}
+
+// Whether the buffer counts can't give a utilization: the session reports no
+// buffers at all
+func (s *ETWSession) noBufferCounts() bool {
+	return s.NumberOfBuffers == 0
+}
+
+// Whether the session is a private (in-process) logger. Its buffers are
+// allocated in the owning process rather than the kernel's pool, and it has
+// no logger thread of its own.
+func (s *ETWSession) IsPrivateLogger() bool {
+	return s.LogFileMode&(EVENT_TRACE_PRIVATE_LOGGER_MODE|EVENT_TRACE_PRIVATE_IN_PROC) != 0
+}
+
+func (s *ETWSession) IsFileBacked() bool {
+	return s.LogFileName != "" ||
+		s.LogFileMode&(EVENT_TRACE_FILE_MODE_SEQUENTIAL|EVENT_TRACE_FILE_MODE_CIRCULAR|EVENT_TRACE_FILE_MODE_NEWFILE) != 0
+}
+
+func (s *ETWSession) IsRealTime() bool {
+	return s.LogFileMode&EVENT_TRACE_REAL_TIME_MODE != 0
+}
+
+// LogFileMode bits with their labels, in bit order
+var logFileModes = []struct {
+	flag uint32
+	name string
+}{
+	{EVENT_TRACE_FILE_MODE_SEQUENTIAL, "Sequential"},
+	{EVENT_TRACE_FILE_MODE_CIRCULAR, "Circular"},
+	{EVENT_TRACE_FILE_MODE_APPEND, "Append"},
+	{EVENT_TRACE_FILE_MODE_NEWFILE, "NewFile"},
+	{EVENT_TRACE_FILE_MODE_PREALLOCATE, "Preallocate"},
+	{EVENT_TRACE_NONSTOPPABLE_MODE, "Nonstoppable"},
+	{EVENT_TRACE_SECURE_MODE, "Secure"},
+	{EVENT_TRACE_REAL_TIME_MODE, "RealTime"},
+	{EVENT_TRACE_BUFFERING_MODE, "Buffering"},
+	{EVENT_TRACE_PRIVATE_LOGGER_MODE, "PrivateLogger"},
+	{EVENT_TRACE_ADD_HEADER_MODE, "AddHeader"},
+	{EVENT_TRACE_USE_KBYTES_FOR_SIZE, "KBytesForSize"},
+	{EVENT_TRACE_USE_GLOBAL_SEQUENCE, "GlobalSequence"},
+	{EVENT_TRACE_USE_LOCAL_SEQUENCE, "LocalSequence"},
+	{EVENT_TRACE_RELOG_MODE, "Relog"},
+	{EVENT_TRACE_PRIVATE_IN_PROC, "PrivateInProc"},
+	{EVENT_TRACE_STOP_ON_HYBRID_SHUTDOWN, "StopOnHybridShutdown"},
+	{EVENT_TRACE_PERSIST_ON_HYBRID_SHUTDOWN, "PersistOnHybridShutdown"},
+	{EVENT_TRACE_USE_PAGED_MEMORY, "PagedMemory"},
+	{EVENT_TRACE_SYSTEM_LOGGER_MODE, "SystemLogger"},
+	{EVENT_TRACE_INDEPENDENT_SESSION_MODE, "IndependentSession"},
+	{EVENT_TRACE_NO_PER_PROCESSOR_BUFFERING, "NoPerProcessorBuffering"},
+	{EVENT_TRACE_ADDTO_TRIAGE_DUMP, "AddToTriageDump"},
+}
+
+// LogFileMode as comma-separated labels such as "Sequential, RealTime".
+// Unknown bits are added as hex, and a mode of 0 reads "None".
+func (s *ETWSession) LogFileModeString() string {
+	if s.LogFileMode == 0 {
+		return "None"
+	}
+	var names []string
+	remaining := s.LogFileMode
+	for _, mode := range logFileModes {
+		if s.LogFileMode&mode.flag != 0 {
+			names = append(names, mode.name)
+			remaining &^= mode.flag
+		}
+	}
+	if remaining != 0 {
+		names = append(names, fmt.Sprintf("0x%08X", remaining))
+	}
+	return strings.Join(names, ", ")
+}
+
 // Windows API declarations
 var (
 	advapi32            = syscall.NewLazyDLL("advapi32.dll")
 	procQueryAllTracesW = advapi32.NewProc("QueryAllTracesW")
-	// procQueryTraceW     = advapi32.NewProc("QueryTraceW")
-	// procControlTraceW   = advapi32.NewProc("ControlTraceW")
+	procQueryTraceW     = advapi32.NewProc("QueryTraceW")
+	procControlTraceW   = advapi32.NewProc("ControlTraceW")
+
+	// QueryAllTracesW as queryAllTraces calls it, replaceable so tests can
+	// stand in for the API
+	queryAllTracesW = callQueryAllTracesW
 )
 
+// Call QueryAllTracesW with an array of capacity properties pointers, or
+// nil to only ask for the count
+func callQueryAllTracesW(sessionArray *uintptr, capacity uint32, sessionCount *uint32) uintptr {
+	ret, _, _ := procQueryAllTracesW.Call(
+		uintptr(unsafe.Pointer(sessionArray)),
+		uintptr(capacity),
+		uintptr(unsafe.Pointer(sessionCount)),
+	)
+	return ret
+}
+
 // Helper function to convert UTF16 pointer to Go string
-func utf16PtrToString(ptr *uint16) string {
+func utf16PtrToString(ptr *uint16, maxLen int) string {
 	if ptr == nil {
 		return ""
 	}
 
-	// Find the length of the string
+	// Find the length of the string, stopping at maxLen characters so an
+	// unterminated string can't run past the end of its buffer
 	length := 0
-	for {
+	for length < maxLen {
 		if *(*uint16)(unsafe.Pointer(uintptr(unsafe.Pointer(ptr)) + uintptr(length*2))) == 0 {
 			break
 		}
@@ -125,46 +368,612 @@ func utf16PtrToString(ptr *uint16) string {
 type ETWBufferMonitor struct {
 	monitoring bool
 	sessions   []ETWSession
+	remotePath string // CSV published by another machine; empty queries the local system
+
+	// -hosts: feeds of other instances running with -api
+	hosts        []string
+	hostMu       sync.Mutex
+	hostSessions map[string][]ETWSession // Last good sessions per host
+	hostErrors   map[string]error        // Result of the last poll per host
+
+	// -query-api single: query these sessions one by one with QueryTraceW
+	// instead of listing every session with QueryAllTracesW
+	queryAPI   string
+	queryNames []string
+
+	// Properties blocks kept from one QueryAllTracesW call to the next
+	blocks propertyBlocks
+
+	anonymizer *anonymizer // Set by -anonymize
 }
 
 func NewETWBufferMonitor() *ETWBufferMonitor {
 	return &ETWBufferMonitor{
-		monitoring: false,
-		sessions:   make([]ETWSession, 0),
+		monitoring:   false,
+		sessions:     make([]ETWSession, 0),
+		hostSessions: make(map[string][]ETWSession),
+		hostErrors:   make(map[string]error),
+		queryAPI:     "all",
 	}
 }
 
+// Options controlling a monitoring run
+type monitorOptions struct {
+	intervalSeconds int
+	showOnce        bool
+	count           int           // Queries to take before exiting; 0 runs until quit
+	headless        bool          // Poll without the TUI, printing a line per query
+	tailNew         bool          // Headless, printing only sessions appearing and disappearing
+	stream          bool          // Headless, writing each poll's sessions to stdout as NDJSON
+	jsonFields      []int         // sessionJSON fields -stream writes; nil writes all
+	changeFields    []changeField // Values whose movement counts as a change
+	grid            bool          // Heatmap of blocks instead of table rows
+	perSecond       bool          // Per-interval columns as rates per second
+	reconcileEvery  time.Duration // How often tracking state of departed sessions is dropped; 0 never
+	adaptive        bool          // Back off the interval while nothing changes
+	rateWindow      int           // Intervals the per-interval columns are averaged over
+	idlePolls       int           // Polls without buffers written before a session counts as idle
+	warnRTLost      uint32        // Real-time buffers lost per interval that mark a session; 0 disables
+	depleteRate     float64       // Free-buffer drop per interval, in % of allocated buffers, that triggers a warning
+	pinned          []string      // Session names that stay visible even when absent
+	tags            []tagRule     // Session labels from -tags
+	policy          *bufferPolicy // Expected buffer configuration from -policy
+	expected        []string      // Session names that must be running; absence is a warning
+	sortKey         string        // Initial sort column, one of sortKeys
+	gauge           string        // Utilization column mode, one of gaugeModes
+	detailMode      string        // Detail pane behavior on new queries, one of detailModes
+	layout          string        // Where the status lines go, one of layouts
+	timeFormat      string        // How the header shows the time, one of timeFormats
+	rollup          int           // Start in the rollup view grouping by this many name segments; 0 starts flat
+	runReport       string        // File to write the closing run report to
+	sortDesc        bool          // Initial sort direction
+	icons           bool          // Show the status glyph column
+	arrows          bool          // Mark counter cells with the direction they moved
+	ascii           bool          // Use ASCII instead of Unicode/emoji glyphs
+	logCSV          string        // Append every poll to this CSV file
+	serveMetrics    string        // Serve the latest poll as Prometheus metrics at this address
+	logMaxSize      int64         // Rotate the CSV log at this size in bytes; 0 never rotates
+	logRotate       string        // Also rotate the CSV log hourly or daily; "" doesn't
+	exportHistory   string        // Write the sessions' history as JSON to this file on quit
+	logMaxFiles     int           // Rotated CSV logs to keep; 0 keeps all
+	flushInterval   time.Duration // Buffer -log-csv rows and write them at most this often
+	onWarn          string        // Command run when a session enters a warning state
+	onWarnCooldown  time.Duration
+	logFile         string // Write background activity (hook runs) to this file
+	checkLogFiles   bool   // Check that file-backed sessions can write their log file
+	hideWriteDelta  bool   // Leave out the buffers-written-per-interval column
+	hideLegend      bool   // Start with the color legend hidden
+	human           bool   // Abbreviate large counters in the table
+	hideSummary     bool   // Start with the summary box hidden
+	hideWarnings    bool   // Start with the warning box hidden
+}
+
+// Sort columns accepted by -sort and cycled with the s key
+var sortKeys = []string{"name", "util", "memory", "lost", "index"}
+
+// Utilization column modes accepted by -gauge and cycled with the b key
+var gaugeModes = []string{"numeric", "bar", "both"}
+
+// Triage lenses cycled with the v key: every session, only sessions in a
+// warning state, or only sessions that changed in the last poll
+var viewModes = []string{"all", "warnings", "changed"}
+
+// Placements accepted by -layout: the status lines above the table, or
+// below it with the table filling the terminal from the bottom
+var layouts = []string{"top", "bottom"}
+
+// Header time formats accepted by -time-format
+var timeFormats = []string{"absolute", "relative", "both"}
+
 // Bubble Tea Model for TUI
 type model struct {
 	monitor          *ETWBufferMonitor
 	sessions         []ETWSession
-	previousSessions map[string]ETWSession // Track previous state for change detection
+	previousSessions map[string]ETWSession      // Track previous state for change detection, by Key
+	history          map[string]*sessionHistory // Recent samples per session, by Key
+	statsReset       time.Time                  // When R last cleared the accumulated statistics
+	pinned           map[string]bool            // Session names kept visible while absent
+	tags             []tagRule                  // Session labels, first match wins
+	policy           *bufferPolicy              // Expected buffer configuration; nil without -policy
+	expected         map[string]bool            // Session names whose absence is a warning
+	frozen           map[string]bool            // Session keys held at the top of the table
+	selected         int                        // Index of the selected row
+	showDetail       bool                       // Detail pane for the selected row is open
+	detailMode       string                     // One of detailModes
+	showRollup       bool                       // Group sessions by name prefix
+	rollupDepth      int                        // Name segments a rollup group shares
+	expandedGroups   map[string]bool            // Rollup groups showing their sessions, by prefix
+	flagChanges      map[string]flagChange      // Latest enable-flags change per session since the last reset
+	run              *runStats                  // Totals for the closing report
+	detailRow        displayRow                 // Row the detail pane was opened on, with its values then
+	detailAt         time.Time                  // Query time of detailRow
+	filterInput      bool                       // Filter bar has focus and takes keystrokes
+	filter           string                     // Only sessions whose name matches are shown
+	fuzzyFilter      bool                       // Match the filter as a subsequence instead of a substring
+	sortKey          string
+	gauge            string // Utilization column mode, one of gaugeModes
+	sortDesc         bool
+	icons            bool
+	ascii            bool
+	showWriteDelta   bool // Show buffers written per interval and dim idle writers
+	fullWidth        bool // Never truncate session names (text export)
+	showLegend       bool // Explain the row colors under the summary
+	humanNumbers     bool // Abbreviate large counters (12.3K, 1.2M) in the table
+	showSummary      bool // Render the summary box
+	showWarnings     bool // Render the warning box
 	lastUpdate       time.Time
+	queryDuration    time.Duration   // How long the last QueryAllSessions call took
+	queryTimes       []time.Duration // The last queryTimeWindow query times, oldest first
+	checkLogFiles    bool
+	logFileProblems  map[string]string // Log file problem by session key, with -check-logfiles
+	hostStatus       map[string]error  // Last poll result per host with -hosts; nil means reachable
 	intervalSeconds  int
+	adaptive         bool
+	depleteRate      float64
+	warnRTLost       uint32        // Real-time buffers lost per interval that mark a session; 0 disables
+	rateWindow       int           // Intervals the per-interval columns are averaged over; 1 shows the last delta
+	idlePolls        int           // Polls without buffers written before a session counts as idle
+	hideIdle         bool          // Leave idle sessions out of the table
+	intervalCounters bool          // Show per-interval deltas in place of the cumulative counters
+	grid             bool          // Show sessions as a heatmap of blocks instead of table rows
+	perSecond        bool          // Show the per-interval columns as rates per second
+	arrows           bool          // Mark counter cells that moved since the previous query
+	viewMode         string        // One of viewModes; applied after the name filter
+	width            int           // Terminal width from the last resize; 0 until known
+	height           int           // Terminal height from the last resize; 0 until known
+	layout           string        // One of layouts
+	timeFormat       string        // One of timeFormats
+	reconcileEvery   time.Duration // Interval between reconciliations; 0 disables them
+	changeFields     []changeField // Values whose movement counts as a change
+	lastReconcile    time.Time     // When tracking state was last rebuilt from the live sessions
+	lastChange       time.Time     // Last query whose values differed from the one before
+	refreshInterval  time.Duration // Current poll interval; differs from the base in adaptive mode
 	showOnce         bool
+	refreshes        int       // Queries taken in so far
+	maxRefreshes     int       // Quit after this many queries with -count; 0 runs until quit
+	sinks            *sinkSet  // Outputs every query is published to (CSV log, metrics)
+	warnHook         *warnHook // -on-warn command, nil when disabled
 	err              error
 	exiting          bool
 }
 
+// A table row: either a live session or a placeholder for an absent pinned
+// or expected name
+type displayRow struct {
+	session ETWSession
+	absent  bool
+	missing bool          // Absent and expected to be running
+	group   *sessionGroup // Rollup row standing for a group of sessions
+}
+
 // Message types for Bubble Tea
 type tickMsg time.Time
-type sessionsMsg []ETWSession
+type sessionsMsg struct {
+	sessions        []ETWSession
+	duration        time.Duration     // Wall-clock time the query took
+	logFileProblems map[string]string // By session key; only filled with -check-logfiles
+	hostStatus      map[string]error  // Only filled with -hosts
+}
 type errMsg error
 
-func initialModel(intervalSeconds int, showOnce bool) model {
+func initialModel(monitor *ETWBufferMonitor, opts monitorOptions) model {
+	pinned := make(map[string]bool)
+	for _, name := range opts.pinned {
+		pinned[name] = true
+	}
+	expected := make(map[string]bool)
+	for _, name := range opts.expected {
+		expected[name] = true
+	}
+
 	return model{
-		monitor:          NewETWBufferMonitor(),
+		monitor:          monitor,
 		sessions:         []ETWSession{},
 		previousSessions: make(map[string]ETWSession),
-		intervalSeconds:  intervalSeconds,
-		showOnce:         showOnce,
+		sinks:            &sinkSet{},
+		history:          make(map[string]*sessionHistory),
+		pinned:           pinned,
+		tags:             opts.tags,
+		policy:           opts.policy,
+		expected:         expected,
+		frozen:           make(map[string]bool),
+		sortKey:          opts.sortKey,
+		gauge:            opts.gauge,
+		detailMode:       opts.detailMode,
+		layout:           opts.layout,
+		timeFormat:       opts.timeFormat,
+		reconcileEvery:   opts.reconcileEvery,
+		changeFields:     opts.changeFields,
+		grid:             opts.grid,
+		perSecond:        opts.perSecond,
+		lastReconcile:    time.Now(),
+		showRollup:       opts.rollup > 0,
+		rollupDepth:      cmp.Or(opts.rollup, defaultRollupDepth),
+		expandedGroups:   make(map[string]bool),
+		flagChanges:      make(map[string]flagChange),
+		run:              newRunStats(),
+		sortDesc:         opts.sortDesc,
+		icons:            opts.icons,
+		arrows:           opts.arrows,
+		showWriteDelta:   !opts.hideWriteDelta,
+		showLegend:       !opts.hideLegend,
+		humanNumbers:     opts.human,
+		showSummary:      !opts.hideSummary,
+		showWarnings:     !opts.hideWarnings,
+		ascii:            opts.ascii,
+		intervalSeconds:  opts.intervalSeconds,
+		adaptive:         opts.adaptive,
+		depleteRate:      opts.depleteRate,
+		warnRTLost:       opts.warnRTLost,
+		rateWindow:       opts.rateWindow,
+		idlePolls:        opts.idlePolls,
+		viewMode:         "all",
+		refreshInterval:  time.Duration(opts.intervalSeconds) * time.Second,
+		showOnce:         opts.showOnce,
+		maxRefreshes:     opts.count,
+		checkLogFiles:    opts.checkLogFiles,
+		logFileProblems:  make(map[string]string),
 		lastUpdate:       time.Now(),
 	}
 }
 
+// Order two sessions by a sort column; ties are left to the caller
+func compareSessions(a, b ETWSession, key string) int {
+	switch key {
+	case "util":
+		return cmp.Compare(a.UtilizationPercent(), b.UtilizationPercent())
+	case "memory":
+		return cmp.Compare(a.TotalMemoryMB(), b.TotalMemoryMB())
+	case "lost":
+		return cmp.Compare(a.EventsLost, b.EventsLost)
+	case "index":
+		return cmp.Compare(a.Index, b.Index)
+	}
+	return strings.Compare(a.Name, b.Name)
+}
+
+// Sort sessions in place by a sort column, breaking ties by name
+func sortSessions(sessions []ETWSession, key string, desc bool) {
+	sort.SliceStable(sessions, func(i, j int) bool {
+		c := compareSessions(sessions[i], sessions[j], key)
+		if desc {
+			c = -c
+		}
+		if c == 0 {
+			return sessions[i].Name < sessions[j].Name
+		}
+		return c < 0
+	})
+}
+
+// Rows to render: frozen sessions, then the remaining live sessions, each in
+// the active sort order, followed by pinned and expected names not in the
+// current query
+func (m model) displayRows() []displayRow {
+	sessions := make([]ETWSession, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		if m.matchesFilter(session.Name) && !(m.hideIdle && m.isIdle(session)) && m.inView(session) {
+			sessions = append(sessions, session)
+		}
+	}
+	sortSessions(sessions, m.sortKey, m.sortDesc)
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Host < sessions[j].Host
+	})
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return m.frozen[sessions[i].Key()] && !m.frozen[sessions[j].Key()]
+	})
+
+	rows := make([]displayRow, 0, len(sessions)+len(m.pinned)+len(m.expected))
+	present := make(map[string]bool, len(sessions))
+	for _, session := range sessions {
+		if !m.showRollup {
+			rows = append(rows, displayRow{session: session})
+		}
+		present[session.matchName()] = true
+	}
+	if m.showRollup {
+		rows = append(rows, m.rollupRows(sessions)...)
+	}
+
+	var absent []string
+	for name := range m.pinned {
+		if !present[name] && !m.expected[name] && m.matchesFilter(m.shownName(name)) && m.viewMode == "all" {
+			absent = append(absent, name)
+		}
+	}
+	for name := range m.expected {
+		if !present[name] && m.matchesFilter(m.shownName(name)) && m.viewMode != "changed" {
+			absent = append(absent, name)
+		}
+	}
+	sort.Strings(absent)
+	for _, name := range absent {
+		session := ETWSession{Name: m.shownName(name), realName: name}
+		rows = append(rows, displayRow{session: session, absent: true, missing: m.expected[name]})
+	}
+	return rows
+}
+
+// Whether a session passes the current view mode. Missing expected sessions
+// are warnings too, and show in the warnings view.
+func (m model) inView(session ETWSession) bool {
+	switch m.viewMode {
+	case "warnings":
+		_, logProblem := m.logFileProblems[session.Key()]
+		return len(m.sessionConditions(session)) > 0 || logProblem
+	case "changed":
+		previous, existed := m.previousSessions[session.Key()]
+		return existed && m.sessionChanged(previous, session)
+	}
+	return true
+}
+
+// Expected session names not in the current query, sorted, as they are
+// shown. Unlike the rows, this ignores the filter so hidden sessions still
+// raise warnings.
+func (m model) missingExpected() []string {
+	present := make(map[string]bool, len(m.sessions))
+	for _, session := range m.sessions {
+		present[session.matchName()] = true
+	}
+	var missing []string
+	for name := range m.expected {
+		if !present[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for i, name := range missing {
+		missing[i] = m.shownName(name)
+	}
+	return missing
+}
+
+// A session name given on the command line as the table shows it: its
+// pseudonym with -anonymize, given when the name is first shown
+func (m model) shownName(name string) string {
+	if m.monitor.anonymizer == nil {
+		return name
+	}
+	return m.monitor.anonymizer.name(name)
+}
+
+// Rows a page key moves the selection by
+const pageRows = 10
+
+// Move the selection for a navigation key. Moves stop at the first and
+// last row rather than wrapping.
+func (m *model) moveSelection(key string) {
+	// In the grid, up and down move a grid line and left and right one block
+	step := 1
+	if m.grid {
+		step = m.gridColumns()
+	}
+	switch key {
+	case "up", "k":
+		m.selected -= step
+	case "down", "j":
+		m.selected += step
+	case "left", "h":
+		m.selected--
+	case "right", "l":
+		m.selected++
+	case "pgup":
+		m.selected -= pageRows
+	case "pgdown":
+		m.selected += pageRows
+	case "home", "g":
+		m.selected = 0
+	case "end", "G":
+		m.selected = len(m.displayRows()) - 1
+	}
+	m.clampSelection()
+}
+
+// Identity of a row for keeping it selected across queries
+func rowKey(row displayRow) string {
+	if row.group != nil {
+		return "group:" + row.group.prefix
+	}
+	return row.session.Key()
+}
+
+// Select the row with the given key if it is still shown, so the cursor
+// stays on the same session when a query reorders or shrinks the list
+func (m *model) selectKey(key string) {
+	for i, row := range m.displayRows() {
+		if rowKey(row) == key {
+			m.selected = i
+			return
+		}
+	}
+}
+
+// Keep the selection inside the current row range
+func (m *model) clampSelection() {
+	rows := len(m.displayRows())
+	if m.selected >= rows {
+		m.selected = rows - 1
+	}
+	if m.selected < 0 {
+		m.selected = 0
+	}
+}
+
+// A session value that counts as a change when it moves, for -change-fields
+type changeField struct {
+	name  string
+	value func(ETWSession) uint32
+}
+
+// Fields -change-fields accepts; all of them but RealTimeBuffersLost are
+// watched by default
+var changeFields = []changeField{
+	{"NumberOfBuffers", func(s ETWSession) uint32 { return s.NumberOfBuffers }},
+	{"FreeBuffers", func(s ETWSession) uint32 { return s.FreeBuffers }},
+	{"EventsLost", func(s ETWSession) uint32 { return s.EventsLost }},
+	{"BuffersWritten", func(s ETWSession) uint32 { return s.BuffersWritten }},
+	{"EnableFlags", func(s ETWSession) uint32 { return s.EnableFlags }},
+	{"RealTimeBuffersLost", func(s ETWSession) uint32 { return s.RealTimeBuffersLost }},
+}
+
+var defaultChangeFields = []string{"NumberOfBuffers", "FreeBuffers", "EventsLost", "BuffersWritten", "EnableFlags"}
+
+// Look up -change-fields names, ignoring case
+func parseChangeFields(names []string) ([]changeField, error) {
+	var fields []changeField
+	for _, name := range names {
+		i := slices.IndexFunc(changeFields, func(f changeField) bool { return strings.EqualFold(f.name, name) })
+		if i < 0 {
+			valid := make([]string, len(changeFields))
+			for j, field := range changeFields {
+				valid[j] = field.name
+			}
+			return nil, fmt.Errorf("unknown field %q (valid: %s)", name, strings.Join(valid, ", "))
+		}
+		fields = append(fields, changeFields[i])
+	}
+	return fields, nil
+}
+
+// Report whether any of the -change-fields of a session moved between two
+// queries. This one test drives row highlighting, the changed view, the
+// header's last-change time and the adaptive interval.
+func (m model) sessionChanged(previous, current ETWSession) bool {
+	for _, field := range m.changeFields {
+		if field.value(previous) != field.value(current) {
+			return true
+		}
+	}
+	return false
+}
+
+// Report whether a fresh query differs from the sessions currently shown
+func (m model) sessionsChanged(sessions []ETWSession) bool {
+	if len(sessions) != len(m.sessions) {
+		return true
+	}
+
+	current := make(map[string]ETWSession, len(m.sessions))
+	for _, session := range m.sessions {
+		current[session.Key()] = session
+	}
+	for _, session := range sessions {
+		previous, existed := current[session.Key()]
+		if !existed || m.sessionChanged(previous, session) {
+			return true
+		}
+	}
+	return false
+}
+
+// Events lost since the previous query; zero for new sessions and counter resets
+func (m model) lostDelta(session ETWSession) uint32 {
+	previous, existed := m.previousSessions[session.Key()]
+	if !existed || session.EventsLost < previous.EventsLost {
+		return 0
+	}
+	return session.EventsLost - previous.EventsLost
+}
+
+// Real-time buffers lost since the previous query; zero for new sessions and counter resets
+func (m model) rtLostDelta(session ETWSession) uint32 {
+	previous, existed := m.previousSessions[session.Key()]
+	if !existed || session.RealTimeBuffersLost < previous.RealTimeBuffersLost {
+		return 0
+	}
+	return session.RealTimeBuffersLost - previous.RealTimeBuffersLost
+}
+
+// Buffers written since the previous query; zero for new sessions and counter resets
+func (m model) writtenDelta(session ETWSession) uint32 {
+	previous, existed := m.previousSessions[session.Key()]
+	if !existed || session.BuffersWritten < previous.BuffersWritten {
+		return 0
+	}
+	return session.BuffersWritten - previous.BuffersWritten
+}
+
+// Whether a session is losing events right now. A one-shot run has no
+// interval to compare against, so it falls back to the cumulative counter.
+func (m model) losingEvents(session ETWSession) bool {
+	if m.showOnce {
+		return session.EventsLost > 0
+	}
+	return m.lostDelta(session) > 0
+}
+
+// Whether a real-time session's consumer is falling behind: at least
+// -warn-rt-lost buffers were lost this interval, or in total for a
+// one-shot run. Distinct from lost events, which mean the buffers overflowed.
+func (m model) losingRealTimeBuffers(session ETWSession) bool {
+	if m.warnRTLost == 0 || !session.IsRealTime() {
+		return false
+	}
+	if m.showOnce {
+		return session.RealTimeBuffersLost >= m.warnRTLost
+	}
+	return m.rtLostDelta(session) >= m.warnRTLost
+}
+
+// Whether free buffers dropped by at least the configured share of the
+// session's buffers since the previous query, an early sign of coming loss
+func (m model) depletingFreeBuffers(session ETWSession) bool {
+	if m.showOnce || session.NumberOfBuffers == 0 {
+		return false
+	}
+	previous, existed := m.previousSessions[session.Key()]
+	if !existed || session.FreeBuffers >= previous.FreeBuffers {
+		return false
+	}
+	drop := float64(previous.FreeBuffers-session.FreeBuffers) / float64(session.NumberOfBuffers) * 100.0
+	return drop >= m.depleteRate
+}
+
+// Warning conditions a session is currently in, as passed to -on-warn
+func (m model) sessionConditions(session ETWSession) []string {
+	var conditions []string
+	if m.losingRealTimeBuffers(session) {
+		conditions = append(conditions, "rt-buffers-lost")
+	}
+	if _, budgeted := m.lossBudget(session); !budgeted && m.losingEvents(session) {
+		conditions = append(conditions, "lost-events")
+	}
+	if m.overLossBudget(session) {
+		conditions = append(conditions, "loss-budget-exceeded")
+	}
+	if session.UtilizationPercent() > 80 {
+		conditions = append(conditions, "high-utilization")
+	}
+	if m.depletingFreeBuffers(session) {
+		conditions = append(conditions, "depleting-buffers")
+	}
+	if len(m.policyViolations(session)) > 0 {
+		conditions = append(conditions, "policy-violation")
+	}
+	if m.flagsChangedNow(session) {
+		conditions = append(conditions, "flags-changed")
+	}
+	return conditions
+}
+
+// Double the poll interval while idle, and return to the base interval on any change
+func (m *model) adaptInterval(changed bool) {
+	base := time.Duration(m.intervalSeconds) * time.Second
+	if changed {
+		m.refreshInterval = base
+		return
+	}
+	m.refreshInterval *= 2
+	if limit := base * maxAdaptiveMultiplier; m.refreshInterval > limit {
+		m.refreshInterval = limit
+	}
+}
+
 func (m model) Init() tea.Cmd {
 	return tea.Batch(
-		tea.Tick(time.Duration(m.intervalSeconds)*time.Second, func(t time.Time) tea.Msg {
+		tea.Tick(m.refreshInterval, func(t time.Time) tea.Msg {
 			return tickMsg(t)
 		}),
 		m.querySessionsCmd(),
@@ -173,41 +982,154 @@ func (m model) Init() tea.Cmd {
 
 func (m model) querySessionsCmd() tea.Cmd {
 	return func() tea.Msg {
+		start := time.Now()
 		sessions, err := m.monitor.QueryAllSessions()
 		if err != nil {
+			activityLog.Error("query failed", "error", err)
 			return errMsg(err)
 		}
-		return sessionsMsg(sessions)
+		msg := sessionsMsg{sessions: sessions, duration: time.Since(start)}
+		activityLog.Debug("query", "sessions", len(sessions), "duration", msg.duration)
+		if len(m.monitor.hosts) > 0 {
+			msg.hostStatus = m.monitor.HostStatus()
+		}
+
+		// Filesystem checks run here, off the UI goroutine, as they add per-poll I/O
+		if m.checkLogFiles {
+			msg.logFileProblems = make(map[string]string)
+			for _, session := range sessions {
+				if problem := checkLogFile(session); problem != "" {
+					msg.logFileProblems[session.Key()] = problem
+				}
+			}
+		}
+		return msg
 	}
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.filterInput && msg.String() != "ctrl+c" {
+			return m.updateFilter(msg), nil
+		}
 		switch msg.String() {
 		case "q", "ctrl+c":
 			m.exiting = true
 			return m, tea.Quit
+		case "up", "k", "down", "j", "left", "h", "right", "l", "pgup", "pgdown", "home", "g", "end", "G":
+			m.moveSelection(msg.String())
+			if m.showDetail {
+				m.openDetail()
+			}
+		case "s":
+			for i, key := range sortKeys {
+				if key == m.sortKey {
+					m.sortKey = sortKeys[(i+1)%len(sortKeys)]
+					break
+				}
+			}
+		case "S":
+			m.sortDesc = !m.sortDesc
+		case "v":
+			for i, mode := range viewModes {
+				if mode == m.viewMode {
+					m.viewMode = viewModes[(i+1)%len(viewModes)]
+					break
+				}
+			}
+			m.clampSelection()
+		case "b":
+			for i, mode := range gaugeModes {
+				if mode == m.gauge {
+					m.gauge = gaugeModes[(i+1)%len(gaugeModes)]
+					break
+				}
+			}
+		case "?":
+			m.showLegend = !m.showLegend
+		case "t":
+			m.showSummary = !m.showSummary
+		case "w":
+			m.showWarnings = !m.showWarnings
+		case "R":
+			m.resetStatistics()
+		case ".":
+			rows := m.displayRows()
+			if m.selected < len(rows) && !rows[m.selected].absent && rows[m.selected].group == nil {
+				key := rows[m.selected].session.Key()
+				if m.frozen[key] {
+					delete(m.frozen, key)
+				} else {
+					m.frozen[key] = true
+				}
+			}
+		case "enter":
+			rows := m.displayRows()
+			if m.selected < len(rows) && rows[m.selected].group != nil {
+				m.toggleGroup(rows[m.selected].group)
+			} else if m.showDetail {
+				m.showDetail = false
+			} else {
+				m.openDetail()
+			}
+		case "i":
+			m.hideIdle = !m.hideIdle
+			m.clampSelection()
+		case "c":
+			m.intervalCounters = !m.intervalCounters
+		case "u":
+			m.showRollup = !m.showRollup
+			m.showDetail = false
+			m.clampSelection()
+		case "r":
+			if m.showDetail {
+				m.followDetail()
+				m.openDetail()
+			}
+		case "esc":
+			if m.showDetail {
+				m.showDetail = false
+			} else {
+				m.filter = ""
+				m.clampSelection()
+			}
+		case "/":
+			m.filterInput = true
+		case "p":
+			rows := m.displayRows()
+			if m.selected < len(rows) && rows[m.selected].group == nil {
+				name := rows[m.selected].session.matchName()
+				if m.pinned[name] {
+					delete(m.pinned, name)
+				} else {
+					m.pinned[name] = true
+				}
+				m.clampSelection()
+			}
 		}
 
 	case tickMsg:
 		if m.showOnce {
 			return m, nil
 		}
+		if m.detailPaused() {
+			return m, tea.Tick(m.refreshInterval, func(t time.Time) tea.Msg {
+				return tickMsg(t)
+			})
+		}
 		return m, tea.Batch(
-			tea.Tick(time.Duration(m.intervalSeconds)*time.Second, func(t time.Time) tea.Msg {
+			tea.Tick(m.refreshInterval, func(t time.Time) tea.Msg {
 				return tickMsg(t)
 			}),
 			m.querySessionsCmd(),
 		)
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
 	case sessionsMsg:
-		// Store previous sessions for change detection
-		for _, session := range m.sessions {
-			m.previousSessions[session.Name] = session
-		}
-		m.sessions = []ETWSession(msg)
-		m.lastUpdate = time.Now()
-		if m.showOnce {
+		m.applySessions(msg)
+		if m.showOnce || (m.maxRefreshes > 0 && m.refreshes >= m.maxRefreshes) {
 			return m, tea.Quit
 		}
 
@@ -218,53 +1140,621 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
-func (m model) View() string {
-	var b strings.Builder
+// Take in a query result: track changes and history, then feed the CSV log
+// and -on-warn. Shared by the TUI and the headless loop.
+func (m *model) applySessions(msg sessionsMsg) {
+	changed := m.sessionsChanged(msg.sessions)
+	if m.adaptive {
+		m.adaptInterval(changed)
+	}
 
-	// Enhanced Styles
-	headerStyle := lipgloss.NewStyle().
-		Bold(true).
-		Foreground(lipgloss.Color("86")).
-		MarginBottom(1)
+	var selectedKey string
+	if rows := m.displayRows(); m.selected < len(rows) {
+		selectedKey = rowKey(rows[m.selected])
+	}
 
-	titleStyle := lipgloss.NewStyle().
-		Bold(true).
-		Foreground(lipgloss.Color("39"))
+	// Store previous sessions for change detection
+	for _, session := range m.sessions {
+		m.previousSessions[session.Key()] = session
+	}
+	m.sessions = msg.sessions
+	m.queryDuration = msg.duration
+	m.queryTimes = append(m.queryTimes, msg.duration)
+	if len(m.queryTimes) > queryTimeWindow {
+		m.queryTimes = slices.Delete(m.queryTimes, 0, 1)
+	}
+	m.logFileProblems = msg.logFileProblems
+	m.hostStatus = msg.hostStatus
+	m.lastUpdate = time.Now()
+	m.err = nil
+	// The header shows when values last changed rather than when they were
+	// last polled, so a static system renders the same frame and Bubble Tea
+	// skips the repaint
+	if changed || m.lastChange.IsZero() {
+		m.lastChange = m.lastUpdate
+		m.run.changedPolls++
+	}
+	m.recordHistory(m.sessions, m.lastUpdate)
+	m.recordFlagChanges()
+	m.recordRun()
+	if m.reconcileEvery > 0 && m.lastUpdate.Sub(m.lastReconcile) >= m.reconcileEvery {
+		m.reconcile()
+	}
+	if m.showDetail {
+		m.followDetail()
+	} else {
+		m.selectKey(selectedKey)
+	}
+	m.clampSelection()
+	m.sinks.publish(m.sessions)
+	if m.warnHook != nil {
+		conditions := make(map[string][]string)
+		names := make(map[string]string)
+		for _, session := range m.sessions {
+			conditions[session.Key()] = m.sessionConditions(session)
+			names[session.Key()] = session.Name
+		}
+		for _, name := range m.missingExpected() {
+			conditions["missing:"+name] = []string{"missing"}
+			names["missing:"+name] = name
+		}
+		m.warnHook.Evaluate(conditions, names)
+	}
+	m.refreshes++
+}
 
-	warningStyle := lipgloss.NewStyle().
-		Bold(true).
-		Foreground(lipgloss.Color("196"))
+// Rebuild the per-session tracking state from the live sessions, dropping
+// entries of sessions that are gone. Over a multi-day run, sessions that
+// stop, restart or reuse a name would otherwise leave state behind that a
+// returning session is compared against. History needs no pruning here:
+// recordHistory drops it for absent sessions on every poll.
+func (m *model) reconcile() {
+	m.lastReconcile = m.lastUpdate
+	live := make(map[string]bool, len(m.sessions))
+	for _, session := range m.sessions {
+		live[session.Key()] = true
+	}
+	pruned := 0
+	for key := range m.previousSessions {
+		if !live[key] {
+			delete(m.previousSessions, key)
+			pruned++
+		}
+	}
+	for key := range m.flagChanges {
+		if !live[key] {
+			delete(m.flagChanges, key)
+			pruned++
+		}
+	}
+	for key := range m.frozen {
+		if !live[key] {
+			delete(m.frozen, key)
+			pruned++
+		}
+	}
+	if m.warnHook != nil {
+		pruned += m.warnHook.prune(m.lastUpdate)
+	}
+	if pruned > 0 {
+		activityLog.Debug("reconciled tracking state", "pruned", pruned, "sessions", len(m.sessions))
+	}
+}
 
-	tableHeaderStyle := lipgloss.NewStyle().
-		Bold(true).
-		Foreground(lipgloss.Color("33"))
+// Table column layout shared by the header and the session rows
+type column struct {
+	title string
+	width int                                // Width in terminal cells
+	value func(m model, s ETWSession) string // Cell text for a session
+}
 
-	summaryBoxStyle := lipgloss.NewStyle().
-		Border(lipgloss.RoundedBorder()).
-		BorderForeground(lipgloss.Color("240")).
-		Padding(0, 1).
-		MarginTop(1).
-		Width(58)
+// Cell showing one of a session's counters, with its change arrow under -arrows
+func counterCell(field func(s ETWSession) uint32) func(model, ETWSession) string {
+	return func(m model, s ETWSession) string {
+		return strconv.FormatUint(uint64(field(s)), 10) + m.cellArrow(s, field)
+	}
+}
 
-	summaryLabelStyle := lipgloss.NewStyle().
-		Bold(true).
-		Foreground(lipgloss.Color("39"))
+// Cell showing a counter that can grow large, abbreviated with -human
+func largeCounterCell(field func(s ETWSession) uint32) func(model, ETWSession) string {
+	return func(m model, s ETWSession) string {
+		if m.humanNumbers {
+			return compactNumber(uint64(field(s))) + m.cellArrow(s, field)
+		}
+		return strconv.FormatUint(uint64(field(s)), 10) + m.cellArrow(s, field)
+	}
+}
+
+// Direction a session's field moved since the previous query: an up or
+// down arrow, or "" when it didn't move or there is nothing to compare
+func (m model) fieldTrend(s ETWSession, field func(s ETWSession) uint32) string {
+	previous, existed := m.previousSessions[s.Key()]
+	if !existed || m.showOnce {
+		return ""
+	}
+	switch current, before := field(s), field(previous); {
+	case current > before:
+		return m.glyph("↑", "^")
+	case current < before:
+		return m.glyph("↓", "v")
+	}
+	return ""
+}
+
+// Change arrow appended to a table cell with -arrows
+func (m model) cellArrow(s ETWSession, field func(s ETWSession) uint32) string {
+	if !m.arrows {
+		return ""
+	}
+	return m.fieldTrend(s, field)
+}
+
+// Abbreviate a count to at most one decimal and a unit: 12345 is 12.3K and
+// 1234567 is 1.2M. Counts under 1000 are shown as is.
+func compactNumber(n uint64) string {
+	if n < 1000 {
+		return strconv.FormatUint(n, 10)
+	}
+	value := float64(n)
+	units := []string{"", "K", "M", "G", "T"}
+	unit := 0
+	// Compare against 999.95 so values that round up to 1000.0 move to the next unit
+	for value >= 999.95 && unit < len(units)-1 {
+		value /= 1000
+		unit++
+	}
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
+
+// Cell showing a per-interval delta; one-shot runs have no interval. With a
+// -rate-window the delta is averaged over the window's intervals.
+func deltaCell(delta func(m model, s ETWSession) uint32, counter func(historySample) uint32) func(model, ETWSession) string {
+	return func(m model, s ETWSession) string {
+		if m.showOnce {
+			return "-"
+		}
+		if m.perSecond {
+			if rate, ok := m.windowedRate(s, counter); ok {
+				return strconv.FormatFloat(rate, 'f', 1, 64)
+			}
+			return "0.0"
+		}
+		if m.rateWindow > 1 {
+			if average, ok := m.windowedDelta(s, counter); ok {
+				return strconv.FormatFloat(average, 'f', 1, 64)
+			}
+		}
+		return strconv.FormatUint(uint64(delta(m, s)), 10)
+	}
+}
+
+// Columns shown in the table, in order. The session name is always first.
+func (m model) columns() []column {
+	columns := []column{
+		{"Session Name", m.nameWidth(), model.nameCell},
+	}
+	if len(m.tags) > 0 {
+		columns = append(columns, m.tagColumn())
+	}
+	columns = append(columns,
+		column{"Buffer(KB)", 12, counterCell(func(s ETWSession) uint32 { return s.BufferSize })},
+		column{"Min", 8, counterCell(func(s ETWSession) uint32 { return s.MinimumBuffers })},
+		column{"Max", 8, counterCell(func(s ETWSession) uint32 { return s.MaximumBuffers })},
+		column{"Current", 8, counterCell(func(s ETWSession) uint32 { return s.NumberOfBuffers })},
+		column{"Free", 6, counterCell(func(s ETWSession) uint32 { return s.FreeBuffers })},
+	)
+	written := column{"Written", 10, largeCounterCell(func(s ETWSession) uint32 { return s.BuffersWritten })}
+	writtenDelta := column{"Wr/Int", 8, deltaCell(model.writtenDelta, func(h historySample) uint32 { return h.buffersWritten })}
+	lost := column{"Lost", 10, largeCounterCell(func(s ETWSession) uint32 { return s.EventsLost })}
+	lostDelta := column{"Lost/Int", 9, deltaCell(model.lostDelta, func(h historySample) uint32 { return h.eventsLost })}
+	if m.perSecond {
+		writtenDelta.title, lostDelta.title = "Wr/s", "Lost/s"
+	}
+	memory := column{"Memory", 12, func(_ model, s ETWSession) string { return formatMemory(s.TotalMemoryMB()) }}
+
+	// The c key swaps the cumulative counters for their per-interval values
+	if m.intervalCounters && !m.showOnce {
+		return append(columns, writtenDelta, lostDelta, m.utilizationColumn(), memory)
+	}
+	columns = append(columns, written)
+	if m.showWriteDelta {
+		columns = append(columns, writtenDelta)
+	}
+	return append(columns, lost, lostDelta, m.utilizationColumn(), memory)
+}
+
+// Width of the session name column. Text exports widen it to the longest
+// name so nothing is truncated.
+func (m model) nameWidth() int {
+	width := 30
+	if m.fullWidth {
+		for _, row := range m.displayRows() {
+			width = max(width, runewidth.StringWidth(row.session.Name)+3)
+		}
+	}
+	return width
+}
+
+// Utilization cell; a session without buffers has no meaningful
+// utilization, and 0.0 would pass it off as idle
+func utilizationCell(_ model, s ETWSession) string {
+	if s.noBufferCounts() {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1f", s.UtilizationPercent())
+}
+
+// Cells in a utilization gauge, between its brackets
+const gaugeWidth = 10
+
+// Utilization column for the gauge mode
+func (m model) utilizationColumn() column {
+	switch m.gauge {
+	case "bar":
+		return column{"Util%", gaugeWidth + 2, model.gaugeCell}
+	case "both":
+		return column{"Util%", gaugeWidth + 8, func(m model, s ETWSession) string {
+			return m.gaugeCell(s) + " " + utilizationCell(m, s)
+		}}
+	}
+	return column{"Util%", 8, utilizationCell}
+}
+
+// Filled part of a session's gauge, empty for sessions without buffers
+func (m model) gaugeFill(s ETWSession) string {
+	if s.noBufferCounts() {
+		return ""
+	}
+	filled := min(max(int(s.UtilizationPercent()/100*gaugeWidth+0.5), 0), gaugeWidth)
+	if m.ascii {
+		return strings.Repeat("#", filled)
+	}
+	return strings.Repeat("█", filled)
+}
+
+// Utilization as a bar such as [███░░░░░░░]
+func (m model) gaugeCell(s ETWSession) string {
+	empty := "░"
+	if m.ascii {
+		empty = "."
+	}
+	fill := m.gaugeFill(s)
+	return "[" + fill + strings.Repeat(empty, gaugeWidth-runewidth.StringWidth(fill)) + "]"
+}
+
+// Color of a gauge's filled part by utilization: green, amber from 50%,
+// orange over the 80% warning threshold
+func gaugeColor(utilization float64) lipgloss.Color {
+	switch {
+	case utilization > 80:
+		return colors.highUtil
+	case utilization >= 50:
+		return colors.depleting
+	}
+	return colors.changed
+}
+
+// Render a table line in its row style, drawing the gauge's filled part in
+// its severity color when the gauge is shown
+func (m model) renderRowLine(row displayRow, rowStyle lipgloss.Style) string {
+	line := m.rowLine(row)
+	fill := m.gaugeFill(row.session)
+	if m.gauge == "numeric" || row.absent || fill == "" {
+		return rowStyle.Render(line)
+	}
+	gauge := m.gaugeCell(row.session)
+	at := strings.LastIndex(line, gauge)
+	if at < 0 {
+		return rowStyle.Render(line)
+	}
+	start, end := at+1, at+1+len(fill)
+	return rowStyle.Render(line[:start]) +
+		rowStyle.Foreground(gaugeColor(row.session.UtilizationPercent())).Render(line[start:end]) +
+		rowStyle.Render(line[end:])
+}
+
+// Session name cell: frozen rows, private loggers and restricted secure
+// loggers are marked, and long
+// names leave at least one cell of space before the next column
+func (m model) nameCell(session ETWSession) string {
+	name := session.Name
+	if session.IsPrivateLogger() {
+		marker := "◇ "
+		if m.ascii {
+			marker = "p "
+		}
+		name = marker + name
+	}
+	if session.restricted() {
+		marker := "⊘ "
+		if m.ascii {
+			marker = "x "
+		}
+		name = marker + name
+	}
+	if m.frozen[session.Key()] {
+		marker := "» "
+		if m.ascii {
+			marker = "> "
+		}
+		name = marker + name
+	}
+	return runewidth.Truncate(name, m.columns()[0].width-1, "")
+}
+
+// Pad or truncate s to exactly width terminal cells, so wide (e.g. CJK)
+// and combining characters don't shift the columns that follow
+func fitCell(s string, width int) string {
+	return runewidth.FillRight(runewidth.Truncate(s, width, ""), width)
+}
+
+// Lay out cell values using the column widths
+func formatRow(columns []column, cells []string) string {
+	parts := make([]string, len(cells))
+	for i, cell := range cells {
+		parts[i] = fitCell(cell, columns[i].width)
+	}
+	return strings.Join(parts, " ")
+}
+
+// One table line for a session
+func (m model) formatSession(session ETWSession) string {
+	columns := m.columns()
+	cells := make([]string, len(columns))
+	for i, col := range columns {
+		cells[i] = col.value(m, session)
+	}
+	return formatRow(columns, cells)
+}
+
+// Unstyled table line for a row, including the optional leading columns
+func (m model) rowLine(row displayRow) string {
+	if row.group != nil {
+		return m.groupLine(row)
+	}
+	if row.absent {
+		nameWidth := m.columns()[0].width
+		status := " not present (pinned)"
+		if row.missing {
+			status = " MISSING (expected)"
+		}
+		return m.rowPrefix(row) + fitCell(runewidth.Truncate(row.session.Name, nameWidth-1, ""), nameWidth) + status
+	}
+	return m.rowPrefix(row) + m.formatSession(row.session)
+}
+
+// Total width of a table line in terminal cells
+func tableWidth(columns []column) int {
+	width := len(columns) - 1
+	for _, col := range columns {
+		width += col.width
+	}
+	return width
+}
+
+// Width of the summary and warning boxes when the terminal size is unknown,
+// as in one-shot output
+const defaultBoxWidth = 58
+
+// Narrowest box width at which the two boxes still go side by side
+const minBoxWidth = 40
+
+// Width of the summary and warning boxes (inside the border) for the
+// terminal width, and whether they are stacked: side by side they split the
+// width, and when that would make them too narrow each takes the full width
+func (m model) boxLayout() (width int, stacked bool) {
+	if m.width == 0 {
+		return defaultBoxWidth, false
+	}
+	// Each box has a one-cell border on both sides
+	full := max(m.width-2, minBoxWidth/2)
+	if !m.showSummary || !m.showWarnings {
+		return full, false
+	}
+	// Two borders and the two-cell gap between the boxes
+	side := (m.width - 6) / 2
+	if side < minBoxWidth {
+		return full, true
+	}
+	return side, false
+}
+
+// The Unicode glyph, or its ASCII stand-in with -ascii
+func (m model) glyph(unicode, ascii string) string {
+	if m.ascii {
+		return ascii
+	}
+	return unicode
+}
+
+// Border of the summary, warning and detail boxes: rounded, or drawn with
+// +, - and | with -ascii
+func (m model) boxBorder() lipgloss.Border {
+	if m.ascii {
+		return lipgloss.ASCIIBorder()
+	}
+	return lipgloss.RoundedBorder()
+}
+
+type legendEntry struct {
+	color lipgloss.Color
+	label string
+}
+
+// One-line explanation of the row colors, drawn in those colors
+func (m model) legend() string {
+	swatch := "■"
+	if m.ascii {
+		swatch = "#"
+	}
+	entries := []legendEntry{
+		{colors.rtLost, "real-time buffers lost"},
+		{colors.lost, "losing events / missing"},
+		{colors.reconfigured, "flags changed"},
+		{colors.highUtil, ">80% util"},
+		{colors.depleting, "depleting"},
+		{colors.changed, "changed"},
+		{colors.initializing, "initializing"},
+	}
+	if m.showWriteDelta {
+		entries = append(entries, legendEntry{colors.idle, "idle"})
+	}
+	entries = append(entries, legendEntry{colors.absent, "absent / offline"})
+
+	parts := make([]string, len(entries))
+	for i, entry := range entries {
+		parts[i] = lipgloss.NewStyle().Foreground(entry.color).Render(swatch + " " + entry.label)
+	}
+	return "Legend: " + strings.Join(parts, "  ")
+}
+
+// Width of the optional status glyph column: health, session type and trend
+const iconColumnWidth = 7
+
+// Width of the Host column shown with -hosts
+const hostColumnWidth = 20
+
+func (m model) showHosts() bool {
+	return len(m.monitor.hosts) > 0
+}
+
+// Width of a table line including the optional leading columns
+func (m model) lineWidth() int {
+	width := tableWidth(m.columns())
+	if m.icons {
+		width += iconColumnWidth + 1
+	}
+	if m.showHosts() {
+		width += hostColumnWidth + 1
+	}
+	return width
+}
+
+// Optional leading columns (status glyphs, host) for a row; "" when disabled
+func (m model) rowPrefix(row displayRow) string {
+	var prefix string
+	if m.icons {
+		switch {
+		case row.group != nil:
+			prefix += fitCell("", iconColumnWidth) + " "
+		case !row.absent:
+			prefix += m.sessionIcons(row.session) + " "
+		case m.ascii:
+			prefix += fitCell("-", iconColumnWidth) + " "
+		default:
+			prefix += fitCell("○", iconColumnWidth) + " "
+		}
+	}
+	if m.showHosts() {
+		host := row.session.Host
+		if row.session.Stale {
+			host += " (offline)"
+		}
+		prefix += fitCell(host, hostColumnWidth) + " "
+	}
+	return prefix
+}
+
+// Titles of the optional leading columns, aligned with rowPrefix
+func (m model) headerPrefix() string {
+	var prefix string
+	if m.icons {
+		prefix += fitCell("", iconColumnWidth) + " "
+	}
+	if m.showHosts() {
+		prefix += fitCell("Host", hostColumnWidth) + " "
+	}
+	return prefix
+}
+
+// Status glyphs for a session: a health dot, file-backed and/or real-time
+// markers, and whether utilization rose or fell since the previous query
+func (m model) sessionIcons(session ETWSession) string {
+	health, file, realTime, up, down := "●", "📁", "📡", "↑", "↓"
+	if m.ascii {
+		health, file, realTime, up, down = "*", "F", "R", "^", "v"
+	}
+
+	var kind string
+	if session.IsFileBacked() {
+		kind += file
+	}
+	if session.IsRealTime() {
+		kind += realTime
+	}
+
+	trend := " "
+	if previous, existed := m.previousSessions[session.Key()]; existed && !m.showOnce {
+		switch current, before := session.UtilizationPercent(), previous.UtilizationPercent(); {
+		case current > before:
+			trend = up
+		case current < before:
+			trend = down
+		}
+	}
+
+	return fitCell(health+" "+fitCell(kind, runewidth.StringWidth(file)*2)+trend, iconColumnWidth)
+}
+
+// Table header line built from the column titles
+func formatHeader(columns []column) string {
+	titles := make([]string, len(columns))
+	for i, col := range columns {
+		titles[i] = col.title
+	}
+	return formatRow(columns, titles)
+}
+
+func (m model) View() string {
+	var b strings.Builder
+
+	// Enhanced Styles
+	headerStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(lipgloss.Color("86")).
+		MarginBottom(1)
+
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(lipgloss.Color("39"))
+
+	warningStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(lipgloss.Color("196"))
+
+	tableHeaderStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(colors.header)
+
+	boxWidth, stacked := m.boxLayout()
+	summaryBoxStyle := lipgloss.NewStyle().
+		Border(m.boxBorder()).
+		BorderForeground(colors.border).
+		Padding(0, 1).
+		MarginTop(1).
+		Width(boxWidth)
+
+	summaryLabelStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(lipgloss.Color("39"))
 
 	summaryValueStyle := lipgloss.NewStyle().
 		Foreground(lipgloss.Color("252"))
 
 	warningBoxStyle := lipgloss.NewStyle().
-		Border(lipgloss.RoundedBorder()).
+		Border(m.boxBorder()).
 		BorderForeground(lipgloss.Color("196")).
 		Padding(0, 1).
 		MarginTop(1).
-		Width(58)
+		Width(boxWidth)
 
 	if m.exiting {
 		return "Shutting down monitor...\n"
 	}
 
-	if m.err != nil {
+	// Once a query has succeeded, a failure keeps its sessions on screen
+	// (dimmed) rather than replacing them with the error
+	if m.err != nil && m.refreshes == 0 {
 		return fmt.Sprintf("Error: %v\nPress q to quit.", m.err)
 	}
 
@@ -273,300 +1763,1090 @@ func (m model) View() string {
 	b.WriteString("\n")
 	b.WriteString(titleStyle.Render(fmt.Sprintf("%d active sessions", len(m.sessions))))
 	b.WriteString("\n")
-	b.WriteString(fmt.Sprintf("Timestamp: %s", m.lastUpdate.Format("2006-01-02 15:04:05")))
+	b.WriteString(m.timestampText(warningStyle))
 	if !m.showOnce {
-		b.WriteString(fmt.Sprintf(" | Refresh: %ds | Press 'q' to quit", m.intervalSeconds))
+		if m.adaptive {
+			b.WriteString(fmt.Sprintf(" | Refresh: %s (adaptive) | Press 'q' to quit", m.refreshInterval))
+		} else {
+			b.WriteString(fmt.Sprintf(" | Refresh: %ds | Press 'q' to quit", m.intervalSeconds))
+		}
+	}
+	if !m.showOnce && m.rateWindow > 1 {
+		b.WriteString(fmt.Sprintf(" | Rates: avg of %d intervals", m.rateWindow))
+	}
+	if m.queryDuration > 0 {
+		query := "Query: " + queryTimeText(slices.Max(m.queryTimes))
+		// A query taking half the interval or more means polling is barely keeping up
+		if !m.showOnce && m.queryDuration >= m.refreshInterval/2 {
+			query = warningStyle.Render(fmt.Sprintf("Query: %s (slow)", m.queryDuration.Round(time.Millisecond)))
+		}
+		b.WriteString(" | " + query)
+	}
+	if m.sortKey != "name" || m.sortDesc {
+		direction := "asc"
+		if m.sortDesc {
+			direction = "desc"
+		}
+		b.WriteString(fmt.Sprintf(" | Sort: %s %s", m.sortKey, direction))
+	}
+	if len(m.pinned) > 0 {
+		b.WriteString(fmt.Sprintf(" | Pinned: %d", len(m.pinned)))
+	}
+	if !m.statsReset.IsZero() {
+		b.WriteString(fmt.Sprintf(" | Stats since reset at %s", m.statsReset.Format("15:04:05")))
+	}
+	if m.detailPaused() {
+		b.WriteString(" | " + warningStyle.Render("Paused"))
+	}
+	if m.hideIdle {
+		b.WriteString(" | Idle hidden")
+	}
+	if m.intervalCounters {
+		b.WriteString(" | Counters: per interval")
+	}
+	if m.viewMode != "all" {
+		b.WriteString(" | " + titleStyle.Render("View: "+m.viewMode))
+	}
+	if m.err != nil {
+		age := time.Since(m.lastUpdate).Round(time.Second)
+		b.WriteString(" | " + warningStyle.Render(fmt.Sprintf("Query failed: %v (last updated %s ago)", m.err, age)))
+	}
+	if m.monitor.remotePath != "" {
+		b.WriteString(fmt.Sprintf(" | Source: %s", m.monitor.remotePath))
+	}
+	if failures := m.sinks.failures(); failures != "" {
+		b.WriteString(" | ")
+		b.WriteString(warningStyle.Render(failures))
 	}
 	b.WriteString("\n")
-	b.WriteString(strings.Repeat("═", 120))
-	b.WriteString("\n\n")
+	if m.filterInput || m.filter != "" {
+		bar := "Filter: " + m.filter
+		if m.filterInput {
+			bar += m.glyph("▌", "_")
+		}
+		mode := "substring"
+		if m.fuzzyFilter {
+			mode = "fuzzy"
+		}
+		bar += fmt.Sprintf(" | %d / %d sessions | %s (Ctrl+F)", m.filterMatches(), len(m.sessions), mode)
+		b.WriteString(titleStyle.Render(bar))
+		b.WriteString("\n")
+	}
+	status := b.String()
+	b.Reset()
 
-	if len(m.sessions) == 0 {
+	rows := m.displayRows()
+	if len(rows) == 0 && m.filter != "" {
+		b.WriteString("No sessions match the filter. Press Esc to clear it.\n")
+		return m.arrange(status, b.String(), "")
+	}
+	if len(rows) == 0 {
 		b.WriteString("No active ETW sessions found.\n")
 		b.WriteString("This may be normal if no ETW tracing is currently active.\n")
-		return b.String()
+		return m.arrange(status, b.String(), "")
 	}
 
 	// Table header
-	b.WriteString(tableHeaderStyle.Render(fmt.Sprintf("%-30s %-12s %-8s %-8s %-8s %-6s %-10s %-10s %-8s %-12s",
-		"Session Name", "Buffer(KB)", "Min", "Max", "Current", "Free", "Written", "Lost", "Util%", "Memory(MB)")))
-	b.WriteString("\n")
-	b.WriteString(strings.Repeat("─", 120))
-	b.WriteString("\n")
+	if !m.grid {
+		b.WriteString(tableHeaderStyle.Render(m.headerPrefix() + formatHeader(m.columns())))
+		b.WriteString("\n")
+		b.WriteString(strings.Repeat(m.glyph("─", "-"), m.lineWidth()))
+		b.WriteString("\n")
+	}
 
 	// Session data
 	var totalMemory float64
 	var totalUtilization float64
 	var totalEventsLost uint32
+	averaged, anomalies := 0, 0
+	tallied, idle := 0, 0
+	tally := func(session ETWSession) {
+		tallied++
+		if m.isIdle(session) {
+			idle++
+		}
+		totalMemory += session.TotalMemoryMB()
+		totalEventsLost += session.EventsLost
+		// An anomalous sample reads as 0% and would drag the average down;
+		// a private logger without buffer counts has no utilization at all
+		if session.anomalous() {
+			anomalies++
+		} else if !(session.noBufferCounts() && (session.IsPrivateLogger() || session.restricted())) {
+			totalUtilization += session.UtilizationPercent()
+			averaged++
+		}
+	}
 
-	for _, session := range m.sessions {
-		sessionName := session.Name
-		if len(sessionName) > 29 {
-			sessionName = sessionName[:29]
-		}
-
-		utilization := session.UtilizationPercent()
-		memory := session.TotalMemoryMB()
-
-		// Check for changes from previous update
-		var rowStyle lipgloss.Style
-		previousSession, existed := m.previousSessions[session.Name]
-
-		hasChanges := existed && (previousSession.NumberOfBuffers != session.NumberOfBuffers ||
-			previousSession.FreeBuffers != session.FreeBuffers ||
-			previousSession.EventsLost != session.EventsLost ||
-			previousSession.BuffersWritten != session.BuffersWritten)
-
-		// Color code based on state and changes
-		if session.EventsLost > 0 {
-			rowStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("196")) // Red for lost events
-		} else if utilization > 80 {
-			rowStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("208")) // Orange for high utilization
-		} else if hasChanges && !m.showOnce {
-			rowStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("120")) // Subtle green for changes
-		} else {
-			rowStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("252")) // Normal
-		}
-
-		line := fmt.Sprintf("%-30s %-12d %-8d %-8d %-8d %-6d %-10d %-10d %-8.1f %-12.1f",
-			sessionName,
-			session.BufferSize,
-			session.MinimumBuffers,
-			session.MaximumBuffers,
-			session.NumberOfBuffers,
-			session.FreeBuffers,
-			session.BuffersWritten,
-			session.EventsLost,
-			utilization,
-			memory)
-
-		b.WriteString(rowStyle.Render(line))
-		b.WriteString("\n")
+	for i, row := range rows {
+		session := row.session
+		if row.group != nil {
+			groupStyle := lipgloss.NewStyle().Bold(true).Foreground(colors.normal)
+			if m.err != nil {
+				groupStyle = groupStyle.Foreground(colors.stale)
+			}
+			if i == m.selected && !m.showOnce {
+				groupStyle = groupStyle.Reverse(true)
+			}
+			if !m.grid {
+				b.WriteString(groupStyle.Render(m.rowLine(row)))
+				b.WriteString("\n")
+			}
+			// An expanded group's sessions are counted from their own rows
+			if !m.expandedGroups[row.group.prefix] && m.viewMode == "all" {
+				for _, member := range row.group.sessions {
+					tally(member)
+				}
+			}
+			continue
+		}
+		if row.absent {
+			absentStyle := lipgloss.NewStyle().Foreground(colors.absent)
+			if row.missing {
+				absentStyle = lipgloss.NewStyle().Bold(true).Foreground(colors.lost)
+			}
+			if i == m.selected && !m.showOnce {
+				absentStyle = absentStyle.Reverse(true)
+			}
+			if !m.grid {
+				b.WriteString(absentStyle.Render(m.rowLine(row)))
+				b.WriteString("\n")
+			}
+			continue
+		}
 
-		totalMemory += memory
-		totalUtilization += utilization
-		totalEventsLost += session.EventsLost
+		rowStyle, _ := m.sessionStyle(session)
+		if i == m.selected && !m.showOnce {
+			rowStyle = rowStyle.Reverse(true)
+		}
+
+		if !m.grid {
+			b.WriteString(m.renderRowLine(row, rowStyle))
+			b.WriteString("\n")
+		}
+
+		if m.viewMode == "all" {
+			tally(session)
+		}
+	}
+	if m.grid {
+		b.WriteString(m.renderGrid(rows))
 	}
+	// A view mode only narrows the table; the summary still covers every
+	// session the name filter lets through
+	if m.viewMode != "all" {
+		for _, session := range m.sessions {
+			if m.matchesFilter(session.Name) {
+				tally(session)
+			}
+		}
+	}
+
+	// Detail pane for the selected row
+	if m.showDetail && !m.showOnce {
+		if row, ok := m.detailContent(rows); ok {
+			b.WriteString(m.renderDetail(row))
+			b.WriteString("\n")
+		}
+	}
+
+	table := b.String()
+	b.Reset()
+
 	// Clean Summary Section
 	b.WriteString("\n")
 
 	var summaryContent strings.Builder
 	summaryContent.WriteString(summaryLabelStyle.Render("Summary") + "\n")
+	// Like the other totals, the count covers only what the filter lets through
+	total := fmt.Sprintf("%d", len(m.sessions))
+	if m.filter != "" {
+		total = fmt.Sprintf("%d of %d (filtered)", m.filterMatches(), len(m.sessions))
+	}
 	summaryContent.WriteString(fmt.Sprintf("%-20s %s\n",
 		summaryValueStyle.Render("Total Sessions:"),
-		summaryLabelStyle.Render(fmt.Sprintf("%d", len(m.sessions)))))
+		summaryLabelStyle.Render(total)))
 	summaryContent.WriteString(fmt.Sprintf("%-20s %s\n",
 		summaryValueStyle.Render("Total Memory:"),
-		summaryLabelStyle.Render(fmt.Sprintf("%.1f MB", totalMemory))))
-	if len(m.sessions) > 0 {
+		summaryLabelStyle.Render(formatMemory(totalMemory))))
+	if m.showHosts() {
+		offline := 0
+		for _, err := range m.hostStatus {
+			if err != nil {
+				offline++
+			}
+		}
+		summaryContent.WriteString(fmt.Sprintf("%-20s %s\n",
+			summaryValueStyle.Render("Hosts:"),
+			summaryLabelStyle.Render(fmt.Sprintf("%d (%d offline)", len(m.monitor.hosts), offline))))
+	}
+	if !m.showOnce {
+		summaryContent.WriteString(fmt.Sprintf("%-20s %s\n",
+			summaryValueStyle.Render("Active / Idle:"),
+			summaryLabelStyle.Render(fmt.Sprintf("%d / %d", tallied-idle, idle))))
+	}
+	if averaged > 0 {
 		summaryContent.WriteString(fmt.Sprintf("%-20s %s\n",
 			summaryValueStyle.Render("Avg Utilization:"),
-			summaryLabelStyle.Render(fmt.Sprintf("%.1f%%", totalUtilization/float64(len(m.sessions))))))
+			summaryLabelStyle.Render(fmt.Sprintf("%.1f%%", totalUtilization/float64(averaged)))))
+	}
+	if anomalies > 0 {
+		summaryContent.WriteString(fmt.Sprintf("%-20s %s\n",
+			summaryValueStyle.Render("Anomalous:"),
+			summaryValueStyle.Render(fmt.Sprintf("%d (free > allocated, not averaged)", anomalies))))
 	}
 	summaryContent.WriteString(fmt.Sprintf("%-20s %s",
 		summaryValueStyle.Render("Total Events Lost:"),
 		summaryLabelStyle.Render(fmt.Sprintf("%d", totalEventsLost))))
+	if m.viewMode != "all" {
+		summaryContent.WriteString("\n" + summaryValueStyle.Render(fmt.Sprintf("Totals cover all sessions; the %s view shows %d", m.viewMode, len(rows))))
+	}
 
 	summaryBox := summaryBoxStyle.Render(summaryContent.String())
 
 	// Check for warnings and create warning box
 	highUtilSessions := 0
 	lostEventSessions := 0
+	depletingSessions := 0
 	for _, session := range m.sessions {
 		if session.UtilizationPercent() > 80 {
 			highUtilSessions++
 		}
-		if session.EventsLost > 0 {
+		if _, budgeted := m.lossBudget(session); !budgeted && m.losingEvents(session) {
 			lostEventSessions++
 		}
+		if m.depletingFreeBuffers(session) {
+			depletingSessions++
+		}
 	}
 
-	var warningBox string
-	if highUtilSessions > 0 || lostEventSessions > 0 {
-		var warningContent strings.Builder
-		warningContent.WriteString(warningStyle.Render("⚠ Warnings") + "\n")
-		if highUtilSessions > 0 {
-			warningContent.WriteString(fmt.Sprintf("• %d session(s) have high buffer utilization (>80%%)\n", highUtilSessions))
-			warningContent.WriteString("  Consider increasing buffer count\n")
-		}
-		if lostEventSessions > 0 {
-			if highUtilSessions > 0 {
-				warningContent.WriteString("\n")
+	var warnings []string
+	if highUtilSessions > 0 {
+		warnings = append(warnings, fmt.Sprintf("• %d session(s) have high buffer utilization (>80%%)\n"+
+			"  Consider increasing buffer count", highUtilSessions))
+	}
+	if lostEventSessions > 0 {
+		warnings = append(warnings, fmt.Sprintf("• %d session(s) are losing events\n"+
+			"  Increase buffer size or count", lostEventSessions))
+	}
+	if depletingSessions > 0 {
+		warnings = append(warnings, fmt.Sprintf("• %d session(s) depleting free buffers\n"+
+			"  Loss may follow if the drop continues", depletingSessions))
+	}
+	if missing := m.missingExpected(); len(missing) > 0 {
+		warnings = append(warnings, fmt.Sprintf("• %d expected session(s) missing\n", len(missing))+
+			"  "+strings.Join(missing, ", "))
+	}
+	var offlineHosts []string
+	for _, host := range m.monitor.hosts {
+		if err := m.hostStatus[host]; err != nil {
+			offlineHosts = append(offlineHosts, fmt.Sprintf("  %s: %v", host, err))
+		}
+	}
+	if len(offlineHosts) > 0 {
+		warnings = append(warnings, fmt.Sprintf("• %d host(s) not answering\n", len(offlineHosts))+
+			strings.Join(offlineHosts, "\n"))
+	}
+	var violations []string
+	for _, session := range m.sessions {
+		if v := m.policyViolations(session); len(v) > 0 {
+			violations = append(violations, fmt.Sprintf("  %s: %s", runewidth.Truncate(session.Name, 24, "…"), describeViolations(v)))
+		}
+	}
+	if len(violations) > 0 {
+		warnings = append(warnings, fmt.Sprintf("• %d session(s) drift from the buffer policy\n", len(violations))+
+			strings.Join(violations, "\n"))
+	}
+	var overBudget []string
+	for _, session := range m.sessions {
+		if m.overLossBudget(session) {
+			budget, _ := m.lossBudget(session)
+			rate, _ := m.lostPerMinute(session)
+			overBudget = append(overBudget, fmt.Sprintf("  %s: %.1f lost/min (budget %g)", runewidth.Truncate(session.Name, 24, "…"), rate, budget))
+		}
+	}
+	if len(overBudget) > 0 {
+		warnings = append(warnings, fmt.Sprintf("• %d session(s) over their loss budget\n", len(overBudget))+
+			strings.Join(overBudget, "\n"))
+	}
+	var reconfigured []string
+	for _, session := range m.sessions {
+		if change, ok := m.flagChanges[session.Key()]; ok {
+			reconfigured = append(reconfigured, fmt.Sprintf("  %s: 0x%08X -> 0x%08X at %s",
+				runewidth.Truncate(session.Name, 24, "…"), change.before, change.after, change.at.Format("15:04:05")))
+		}
+	}
+	if len(reconfigured) > 0 {
+		warnings = append(warnings, fmt.Sprintf("• %d session(s) had their enable flags changed (R clears)\n", len(reconfigured))+
+			strings.Join(reconfigured, "\n"))
+	}
+	if len(m.logFileProblems) > 0 {
+		var problems strings.Builder
+		problems.WriteString(fmt.Sprintf("• %d file-backed session(s) can't write their log", len(m.logFileProblems)))
+		for _, session := range m.sessions {
+			if problem, ok := m.logFileProblems[session.Key()]; ok {
+				problems.WriteString(fmt.Sprintf("\n  %s: %s", runewidth.Truncate(session.Name, 24, "…"), problem))
 			}
-			warningContent.WriteString(fmt.Sprintf("• %d session(s) have lost events\n", lostEventSessions))
-			warningContent.WriteString("  Increase buffer size or count")
 		}
-		warningBox = warningBoxStyle.Render(warningContent.String())
+		warnings = append(warnings, problems.String())
 	}
 
-	// Place summary and warning boxes side by side
-	if warningBox != "" {
-		bottomSection := lipgloss.JoinHorizontal(lipgloss.Top, summaryBox, "  ", warningBox)
-		b.WriteString(bottomSection)
-	} else {
+	var warningBox string
+	if len(warnings) > 0 {
+		text := strings.Join(warnings, "\n\n")
+		if m.ascii {
+			text = strings.NewReplacer("•", "-", "…", "...").Replace(text)
+		}
+		warningBox = warningBoxStyle.Render(warningStyle.Render(m.glyph("⚠", "[!]")+" Warnings") + "\n" + text)
+	}
+
+	// Place summary and warning boxes side by side, leaving out hidden ones.
+	// Warnings are still computed so -on-warn and check don't depend on them.
+	if !m.showSummary {
+		summaryBox = ""
+	}
+	if !m.showWarnings {
+		warningBox = ""
+	}
+	switch {
+	case summaryBox != "" && warningBox != "" && stacked:
+		b.WriteString(lipgloss.JoinVertical(lipgloss.Left, summaryBox, warningBox))
+	case summaryBox != "" && warningBox != "":
+		b.WriteString(lipgloss.JoinHorizontal(lipgloss.Top, summaryBox, "  ", warningBox))
+	case summaryBox != "":
 		b.WriteString(summaryBox)
+	case warningBox != "":
+		b.WriteString(warningBox)
+	}
+	if m.showLegend {
+		b.WriteString("\n" + m.legend())
+	}
+
+	return m.arrange(status, table, b.String())
+}
+
+// Queries the header's query time covers: it shows the slowest of them
+const queryTimeWindow = 10
+
+// Upper bounds of the header's query time steps
+var queryTimeSteps = []time.Duration{
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+}
+
+// The slowest recent query's time for the header, in steps such as "<10ms"
+// or "<50ms". Query times jitter from poll to poll; the slowest of the
+// window in steps stays put, so a static system still renders the same
+// frame and skips the repaint, while an API that slows down shows at once.
+func queryTimeText(d time.Duration) string {
+	for _, step := range queryTimeSteps {
+		if d < step {
+			return "<" + step.String()
+		}
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
+
+// The header's time in -time-format: when values last changed, how long ago
+// the last successful poll was, or both. The age is worked out on every
+// render, and warns once it spans more than two intervals.
+func (m model) timestampText(warningStyle lipgloss.Style) string {
+	absolute := "Last change: " + m.lastChange.Format("2006-01-02 15:04:05")
+	if m.timeFormat == "absolute" || m.showOnce {
+		return absolute
 	}
+	age := time.Since(m.lastUpdate)
+	relative := fmt.Sprintf("updated %s ago", age.Round(time.Second))
+	if age > 2*m.refreshInterval && !m.detailPaused() {
+		relative = warningStyle.Render(relative)
+	}
+	if m.timeFormat == "relative" {
+		return "Last " + relative
+	}
+	return absolute + " (" + relative + ")"
+}
+
+// Row style of a session for its state, in order of severity. Loss is
+// judged on this interval's delta so a session that lost events long ago
+// isn't flagged forever. alert is set for the warning states, as opposed to
+// changed, idle and normal rows.
+func (m model) sessionStyle(session ETWSession) (style lipgloss.Style, alert bool) {
+	previousSession, existed := m.previousSessions[session.Key()]
+	hasChanges := existed && m.sessionChanged(previousSession, session)
+
+	switch {
+	case session.Stale || m.err != nil:
+		return lipgloss.NewStyle().Foreground(colors.stale), false
+	case session.initializing():
+		return lipgloss.NewStyle().Italic(true).Foreground(colors.initializing), false
+	case m.losingRealTimeBuffers(session):
+		return lipgloss.NewStyle().Foreground(colors.rtLost), true
+	case m.losingEvents(session):
+		return lipgloss.NewStyle().Foreground(colors.lost), true
+	}
+	if _, changed := m.flagChanges[session.Key()]; changed {
+		return lipgloss.NewStyle().Foreground(colors.reconfigured), true
+	}
+	switch {
+	case session.UtilizationPercent() > 80:
+		return lipgloss.NewStyle().Foreground(colors.highUtil), true
+	case m.depletingFreeBuffers(session):
+		return lipgloss.NewStyle().Foreground(colors.depleting), true
+	case hasChanges && !m.showOnce:
+		return lipgloss.NewStyle().Foreground(colors.changed), false
+	case m.showWriteDelta && !m.showOnce && m.isIdle(session):
+		return lipgloss.NewStyle().Foreground(colors.idle), false
+	}
+	return lipgloss.NewStyle().Foreground(m.normalColor(session.matchName())), false
+}
 
+// Cells taken by one session in the grid: a two-cell block and a space
+const gridCellWidth = 3
+
+// Blocks per grid line for the terminal width
+func (m model) gridColumns() int {
+	width := m.width
+	if width == 0 {
+		width = m.lineWidth()
+	}
+	return max((width+1)/gridCellWidth, 1)
+}
+
+// The rows as a heatmap for -grid, one block per row laid out to the
+// terminal width. Sessions in a warning state take that state's row color,
+// blinking while they lose events; the rest go from green to red with
+// utilization. The selected block is drawn as arrows, and its stats are
+// shown under the grid.
+func (m model) renderGrid(rows []displayRow) string {
+	var b strings.Builder
+	columns := m.gridColumns()
+	for i, row := range rows {
+		block := m.glyph("██", "##")
+		var style lipgloss.Style
+		switch {
+		case row.group != nil:
+			style = lipgloss.NewStyle().Foreground(colors.normal)
+			block = m.glyph("▒▒", "%%")
+		case row.missing:
+			style = lipgloss.NewStyle().Foreground(colors.lost)
+			block = m.glyph("░░", "..")
+		case row.absent:
+			style = lipgloss.NewStyle().Foreground(colors.absent)
+			block = m.glyph("░░", "..")
+		default:
+			var alert bool
+			style, alert = m.sessionStyle(row.session)
+			if !alert && !row.session.Stale && m.err == nil && !row.session.noBufferCounts() {
+				style = lipgloss.NewStyle().Foreground(gaugeColor(row.session.UtilizationPercent()))
+			}
+			if m.losingEvents(row.session) || m.losingRealTimeBuffers(row.session) {
+				style = style.Blink(true)
+			}
+		}
+		if i == m.selected && !m.showOnce {
+			block = m.glyph("◀▶", "<>")
+			style = style.Bold(true)
+		}
+		b.WriteString(style.Render(block))
+		if (i+1)%columns == 0 || i == len(rows)-1 {
+			b.WriteString("\n")
+		} else {
+			b.WriteString(" ")
+		}
+	}
+	if m.selected < len(rows) && !m.showOnce {
+		b.WriteString("\n" + m.gridFooter(rows[m.selected]) + "\n")
+	}
 	return b.String()
 }
 
+// One line describing the selected grid block
+func (m model) gridFooter(row displayRow) string {
+	session := row.session
+	switch {
+	case row.group != nil:
+		return fmt.Sprintf("%s* | %d sessions | Memory %s | Lost %d",
+			row.group.prefix, len(row.group.sessions), formatMemory(row.group.memory), row.group.lost)
+	case row.missing:
+		return session.Name + " | missing: expected to be running"
+	case row.absent:
+		return session.Name + " | not running (pinned)"
+	}
+	line := fmt.Sprintf("%s | Util %s%% | Buffers %d (%d free) | Written %d | Lost %d (+%d) | Memory %s",
+		session.Name, utilizationCell(m, session), session.NumberOfBuffers, session.FreeBuffers,
+		session.BuffersWritten, session.EventsLost, m.lostDelta(session), formatMemory(session.TotalMemoryMB()))
+	if conditions := m.sessionConditions(session); len(conditions) > 0 {
+		line += " | " + strings.Join(conditions, ", ")
+	}
+	return line
+}
+
+// Put the sections of the view in -layout order. The top layout has the
+// status lines above the table and the boxes below it. The bottom layout
+// moves the status lines under the boxes and, once the terminal height is
+// known, pads above the table so it grows up from the bottom like tail -f.
+func (m model) arrange(status, table, boxes string) string {
+	rule := strings.Repeat(m.glyph("═", "="), m.lineWidth())
+	if m.layout != "bottom" {
+		return status + rule + "\n\n" + table + boxes
+	}
+	view := table + boxes + "\n\n" + rule + "\n" + strings.TrimSuffix(status, "\n")
+	if lines := lipgloss.Height(view); !m.showOnce && m.height > lines {
+		view = strings.Repeat("\n", m.height-lines) + view
+	}
+	return view
+}
+
+// Render the full statistics of one row, including the cumulative counters
+func (m model) renderDetail(row displayRow) string {
+	detailBoxStyle := lipgloss.NewStyle().
+		Border(m.boxBorder()).
+		BorderForeground(lipgloss.Color("39")).
+		Padding(0, 1).
+		MarginTop(1)
+
+	labelStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(lipgloss.Color("39"))
+
+	session := row.session
+	var content strings.Builder
+	content.WriteString(labelStyle.Render(session.Name) + "\n")
+	switch {
+	case m.detailMode == "snapshot" && m.snapshotAge() > 0:
+		content.WriteString(fmt.Sprintf("Snapshot from %s (%s old, r to refresh)\n", m.detailAt.Format("15:04:05"), m.snapshotAge()))
+	case m.detailPaused():
+		content.WriteString("Polling paused while this pane is open\n")
+	}
+	if row.missing {
+		content.WriteString("MISSING: expected to be running but not in the current query")
+		return detailBoxStyle.Render(content.String())
+	}
+	if row.absent {
+		content.WriteString("Not present in the current query (pinned)")
+		return detailBoxStyle.Render(content.String())
+	}
+
+	field := func(label string, value string) {
+		content.WriteString(fmt.Sprintf("%-24s %s\n", label+":", value))
+	}
+	field("Buffer Size", fmt.Sprintf("%d KB", session.BufferSize))
+	field("Buffers (min/max)", fmt.Sprintf("%d / %d", session.MinimumBuffers, session.MaximumBuffers))
+	trend := func(field func(s ETWSession) uint32) string {
+		if arrow := m.fieldTrend(session, field); arrow != "" {
+			return " " + arrow
+		}
+		return ""
+	}
+	field("Buffers (current/free)", fmt.Sprintf("%d%s / %d%s",
+		session.NumberOfBuffers, trend(func(s ETWSession) uint32 { return s.NumberOfBuffers }),
+		session.FreeBuffers, trend(func(s ETWSession) uint32 { return s.FreeBuffers })))
+	field("Buffers Written", fmt.Sprintf("%d%s", session.BuffersWritten, trend(func(s ETWSession) uint32 { return s.BuffersWritten })))
+	field("Events Lost (total)", fmt.Sprintf("%d%s", session.EventsLost, trend(func(s ETWSession) uint32 { return s.EventsLost })))
+	field("Events Lost (interval)", fmt.Sprintf("%d", m.lostDelta(session)))
+	if m.rateWindow > 1 {
+		if average, ok := m.windowedDelta(session, func(h historySample) uint32 { return h.eventsLost }); ok {
+			field(fmt.Sprintf("Events Lost (avg of %d)", m.rateWindow), fmt.Sprintf("%.1f per interval", average))
+		}
+	}
+	field("Time to Full", m.saturationETA(session))
+	field("RealTime Buffers Lost", fmt.Sprintf("%d%s", session.RealTimeBuffersLost, trend(func(s ETWSession) uint32 { return s.RealTimeBuffersLost })))
+	field("Log File Mode", fmt.Sprintf("0x%08X (%s)", session.LogFileMode, session.LogFileModeString()))
+	if session.IsPrivateLogger() {
+		field("Private Logger", "buffers are in the owning process, not kernel memory; no logger thread")
+	}
+	if session.restricted() {
+		field("Restricted", "secure logger, stats unavailable; the zero counts are not real")
+	}
+	field("Enable Flags", session.enableFlagsText())
+	field("Clock Type", clockTypeName(session.ClockType))
+	field("Logger Thread", loggerThreadText(session.LoggerThreadId))
+	if change, ok := m.flagChanges[session.Key()]; ok {
+		before := session
+		before.EnableFlags = change.before
+		field("Enable Flags (before)", before.enableFlagsText()+", changed at "+change.at.Format("15:04:05"))
+	}
+	field("Log File", session.LogFileName)
+	if problem, ok := m.logFileProblems[session.Key()]; ok {
+		field("Log File Problem", problem)
+	}
+	if session.Guid != "" {
+		field("Session GUID", session.Guid)
+	}
+	if rule := m.sessionTag(session.matchName()); rule != nil {
+		field("Tag", rule.Tag)
+	}
+	for _, violation := range m.policyViolations(session) {
+		field("Policy "+violation.field, fmt.Sprintf("%d, expected %s", violation.actual, violation.expected))
+	}
+	if _, ok := m.lossBudget(session); ok {
+		field("Loss Budget", m.lossBudgetText(session))
+	}
+	// Border and padding take four cells of the table width
+	if chart := m.lostChart(session, m.lineWidth()-4); chart != "" {
+		h := m.history[session.Key()]
+		samples := h.all()
+		lost := session.EventsLost - min(session.EventsLost, samples[0].eventsLost)
+		content.WriteString(fmt.Sprintf("Events lost since %s: %d\n", samples[0].at.Format("15:04:05"), lost))
+		content.WriteString(chart + "\n")
+	}
+
+	return detailBoxStyle.Render(strings.TrimSuffix(content.String(), "\n"))
+}
+
 // Query all active ETW sessions
 func (m *ETWBufferMonitor) QueryAllSessions() ([]ETWSession, error) {
-	var sessionCount uint32
+	sessions, err := m.querySessions()
+	if err != nil || m.anonymizer == nil {
+		return sessions, err
+	}
+	m.anonymizer.apply(sessions)
+	sortByName(sessions)
+	m.sessions = sessions
+	return sessions, nil
+}
 
-	// First call to get the number of sessions
-	ret, _, _ := procQueryAllTracesW.Call(
-		0, // NULL pointer for first call
-		0, // 0 count for first call
-		uintptr(unsafe.Pointer(&sessionCount)),
-	)
+// Query the sessions from the configured source, sorted by name
+func (m *ETWBufferMonitor) querySessions() ([]ETWSession, error) {
+	if len(m.hosts) > 0 {
+		sessions := m.queryHosts()
+		sortByName(sessions)
+		m.sessions = sessions
+		return sessions, nil
+	}
 
-	if ret != ERROR_MORE_DATA {
-		return nil, fmt.Errorf("failed to get session count, error: %d", ret)
+	if m.remotePath != "" {
+		sessions, err := m.ImportFromCSV(m.remotePath)
+		if err != nil {
+			return nil, err
+		}
+		sortByName(sessions)
+		m.sessions = sessions
+		return sessions, nil
 	}
 
-	if sessionCount == 0 {
-		return []ETWSession{}, nil
+	if m.queryAPI == "single" {
+		sessions, err := m.queryNamedSessions()
+		if err != nil {
+			return nil, err
+		}
+		sortByName(sessions)
+		m.sessions = sessions
+		return sessions, nil
 	}
 
-	// Allocate memory for session properties array
-	const propertySize = unsafe.Sizeof(EVENT_TRACE_PROPERTIES{}) + MAX_SESSION_NAME_LEN*2 // Unicode strings
-	buffer := make([]byte, int(sessionCount)*int(propertySize))
-	sessionArray := make([]uintptr, sessionCount)
+	// The blocks are shared, so a slow query overlapping the next poll must
+	// finish copying the sessions out before they are reused
+	m.blocks.mu.Lock()
+	buffer, sessionCount, err := queryAllTraces(&m.blocks)
+	if err != nil {
+		m.blocks.mu.Unlock()
+		return nil, err
+	}
 
+	sessions := make([]ETWSession, 0, sessionCount)
 	for i := uint32(0); i < sessionCount; i++ {
-		// Get a pointer to the current session's properties within the buffer
 		props := (*EVENT_TRACE_PROPERTIES)(unsafe.Pointer(&buffer[i*uint32(propertySize)]))
+		sessions = append(sessions, sessionFromProperties(props, int(i)))
+	}
+	m.blocks.mu.Unlock()
 
-		// Initialize the structure
-		props.Wnode.BufferSize = uint32(propertySize)
-		props.LoggerNameOffset = uint32(unsafe.Sizeof(EVENT_TRACE_PROPERTIES{}))
-		props.LogFileNameOffset = props.LoggerNameOffset + MAX_SESSION_NAME_LEN
+	// Sort sessions by name for consistent output
+	sortByName(sessions)
+	m.sessions = sessions
+	return sessions, nil
+}
 
-		sessionArray[i] = uintptr(unsafe.Pointer(props))
+// Build a session from a properties block the API filled in
+func sessionFromProperties(props *EVENT_TRACE_PROPERTIES, index int) ETWSession {
+	// Extract session name
+	sessionName := propertyString(props, props.LoggerNameOffset)
+
+	// Extract log file name if present
+	var logFileName string
+	if props.LogFileNameOffset > 0 {
+		logFileName = propertyString(props, props.LogFileNameOffset)
 	}
 
-	// Second call to get actual session data
-	ret, _, _ = procQueryAllTracesW.Call(
-		uintptr(unsafe.Pointer(&sessionArray[0])),
-		uintptr(sessionCount),
-		uintptr(unsafe.Pointer(&sessionCount)),
-	)
+	return ETWSession{
+		Name:                sessionName,
+		BufferSize:          props.BufferSize,
+		MinimumBuffers:      props.MinimumBuffers,
+		MaximumBuffers:      props.MaximumBuffers,
+		NumberOfBuffers:     props.NumberOfBuffers,
+		FreeBuffers:         props.FreeBuffers,
+		BuffersWritten:      props.BuffersWritten,
+		EventsLost:          props.EventsLost,
+		RealTimeBuffersLost: props.RealTimeBuffersLost,
+		LogFileMode:         props.LogFileMode,
+		EnableFlags:         props.EnableFlags,
+		ClockType:           props.Wnode.ClientContext,
+		LoggerThreadId:      uint32(props.LoggerThreadId),
+		LogFileName:         logFileName,
+		Guid:                formatGUID(props.Wnode.Guid),
+		Index:               index,
+		Timestamp:           time.Now(),
+	}
+}
 
-	var sessions []ETWSession
+// Read a string stored at offset in a properties block, never reading past
+// the block or more than one name's worth of characters, so a logger name
+// missing its terminator doesn't run on into the log file name. An offset
+// outside the block's string area reads as "".
+func propertyString(props *EVENT_TRACE_PROPERTIES, offset uint32) string {
+	if offset < uint32(propertiesSize) || offset >= uint32(propertySize) {
+		return ""
+	}
+	maxLen := min((int(propertySize)-int(offset))/2, MAX_SESSION_NAME_LEN)
+	return utf16PtrToString((*uint16)(unsafe.Add(unsafe.Pointer(props), offset)), maxLen)
+}
 
-	if ret == ERROR_SUCCESS {
-		for i := uint32(0); i < sessionCount; i++ {
-			props := (*EVENT_TRACE_PROPERTIES)(unsafe.Pointer(sessionArray[i]))
+// Query one session by name with QueryTraceW. found is false when no
+// session of that name is running.
+func queryTraceByName(name string) (session ETWSession, found bool, err error) {
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return ETWSession{}, false, fmt.Errorf("invalid session name %q: %w", name, err)
+	}
+	buffer, _ := allocSessionProperties(1)
+	props := (*EVENT_TRACE_PROPERTIES)(unsafe.Pointer(&buffer[0]))
 
-			// Extract session name
-			namePtr := uintptr(unsafe.Pointer(props)) + uintptr(props.LoggerNameOffset)
-			sessionName := utf16PtrToString((*uint16)(unsafe.Pointer(namePtr)))
+	ret, _, _ := procQueryTraceW.Call(
+		0, // No handle: the session is looked up by name
+		uintptr(unsafe.Pointer(namePtr)),
+		uintptr(unsafe.Pointer(props)),
+	)
+	switch ret {
+	case ERROR_SUCCESS:
+		return sessionFromProperties(props, 0), true, nil
+	case ERROR_WMI_INSTANCE_NOT_FOUND:
+		return ETWSession{}, false, nil
+	}
+	return ETWSession{}, false, fmt.Errorf("failed to query session %s, error: %d", name, ret)
+}
 
-			// Extract log file name if present
-			var logFileName string
-			if props.LogFileNameOffset > 0 {
-				logFilePtr := uintptr(unsafe.Pointer(props)) + uintptr(props.LogFileNameOffset)
-				logFileName = utf16PtrToString((*uint16)(unsafe.Pointer(logFilePtr)))
-			}
+// Returned by QuerySession when no session of the name is running
+var errSessionNotFound = errors.New("not running")
 
-			session := ETWSession{
-				Name:                sessionName,
-				BufferSize:          props.BufferSize,
-				MinimumBuffers:      props.MinimumBuffers,
-				MaximumBuffers:      props.MaximumBuffers,
-				NumberOfBuffers:     props.NumberOfBuffers,
-				FreeBuffers:         props.FreeBuffers,
-				BuffersWritten:      props.BuffersWritten,
-				EventsLost:          props.EventsLost,
-				RealTimeBuffersLost: props.RealTimeBuffersLost,
-				LogFileMode:         props.LogFileMode,
-				LogFileName:         logFileName,
-				Timestamp:           time.Now(),
-			}
+// Query one session by name for -session, anonymized like the table. Unlike
+// queryTraceByName, a session that isn't running is an error.
+func (m *ETWBufferMonitor) QuerySession(name string) (*ETWSession, error) {
+	session, found, err := queryTraceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("session %q: %w", name, errSessionNotFound)
+	}
+	if m.anonymizer != nil {
+		sessions := []ETWSession{session}
+		m.anonymizer.apply(sessions)
+		session = sessions[0]
+	}
+	return &session, nil
+}
 
+// Returned by StopSession when the caller may not control the session
+var errStopDenied = errors.New("access denied")
+
+// Stop a session by name with ControlTraceW, as "logman stop -ets" does
+func (m *ETWBufferMonitor) StopSession(name string) error {
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return fmt.Errorf("invalid session name %q: %w", name, err)
+	}
+	buffer, _ := allocSessionProperties(1)
+	props := (*EVENT_TRACE_PROPERTIES)(unsafe.Pointer(&buffer[0]))
+
+	ret, _, _ := procControlTraceW.Call(
+		0, // No handle: the session is looked up by name
+		uintptr(unsafe.Pointer(namePtr)),
+		uintptr(unsafe.Pointer(props)),
+		EVENT_TRACE_CONTROL_STOP,
+	)
+	switch ret {
+	case ERROR_SUCCESS:
+		return nil
+	case ERROR_WMI_INSTANCE_NOT_FOUND:
+		return fmt.Errorf("no session named %q is running", name)
+	case ERROR_ACCESS_DENIED:
+		return fmt.Errorf("stopping %s: %w", name, errStopDenied)
+	}
+	return fmt.Errorf("failed to stop session %s, error: %d", name, ret)
+}
+
+// Query the -query-api single names one by one. Sessions that aren't running
+// are left out, as QueryAllTracesW would; Index is the position in the names.
+func (m *ETWBufferMonitor) queryNamedSessions() ([]ETWSession, error) {
+	sessions := make([]ETWSession, 0, len(m.queryNames))
+	for i, name := range m.queryNames {
+		session, found, err := queryTraceByName(name)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			session.Index = i
 			sessions = append(sessions, session)
 		}
-	} else {
-		return nil, fmt.Errorf("failed to query sessions, error: %d", ret)
 	}
+	return sessions, nil
+}
 
-	// Sort sessions by name for consistent output
-	sort.Slice(sessions, func(i, j int) bool {
-		return sessions[i].Name < sessions[j].Name
+// Query the local sessions' properties blocks with QueryAllTracesW into
+// blocks, returning the buffer holding them and how many it holds
+func queryAllTraces(blocks *propertyBlocks) ([]byte, uint32, error) {
+	var sessionCount uint32
+
+	// First call to get the number of sessions
+	ret := queryAllTracesW(nil, 0, &sessionCount)
+
+	// ERROR_MORE_DATA is the normal answer when sessions exist. Some systems
+	// answer ERROR_SUCCESS instead when there are none, which is not a failure.
+	switch ret {
+	case ERROR_MORE_DATA:
+	case ERROR_SUCCESS:
+		activityLog.Debug("probe reported no sessions", "count", sessionCount)
+		return nil, 0, nil
+	default:
+		return nil, 0, fmt.Errorf("failed to get session count, error: %d", ret)
+	}
+
+	if sessionCount == 0 {
+		return nil, 0, nil
+	}
+
+	// Sessions can start between the probe and the data call. The data call
+	// then fails with ERROR_MORE_DATA and reports the new count, so allocate
+	// for that and try again rather than trusting the probe.
+	var buffer []byte
+	capacity := sessionCount
+	for attempt := 1; ; attempt++ {
+		var sessionArray []uintptr
+		buffer, sessionArray = blocks.prepare(capacity)
+
+		ret = queryAllTracesW(&sessionArray[0], capacity, &sessionCount)
+		if ret != ERROR_MORE_DATA || sessionCount <= capacity || attempt == maxQueryAttempts {
+			break
+		}
+		activityLog.Debug("session count grew during query, retrying", "allocated", capacity, "reported", sessionCount, "attempt", attempt)
+		capacity = sessionCount
+	}
+
+	switch ret {
+	case ERROR_SUCCESS:
+	case ERROR_MORE_DATA:
+		// Still growing after the last attempt. Windows fills the array as
+		// far as it goes in that case, so show those sessions; the rest
+		// appear on the next poll.
+		activityLog.Debug("session count still growing, showing the allocated sessions", "allocated", capacity, "reported", sessionCount)
+	default:
+		return nil, 0, fmt.Errorf("failed to query sessions, error: %d", ret)
+	}
+	// Never read past what was allocated, whatever count the API reports
+	return buffer, min(sessionCount, capacity), nil
+}
+
+// Size of EVENT_TRACE_PROPERTIES as Windows lays it out: 120 bytes on both
+// x86 and x64. The Go struct is 116 bytes on 386, which aligns the uint64
+// WNODE_HEADER fields to 4 rather than 8, so it is rounded up here.
+const propertiesSize = (unsafe.Sizeof(EVENT_TRACE_PROPERTIES{}) + 7) &^ 7
+
+// Compile-time checks of the layout against the Windows headers. A
+// mismatch fails the build with an out-of-range array length.
+var (
+	_ [120 - propertiesSize]struct{}
+	_ [propertiesSize - 120]struct{}
+	_ [104 - unsafe.Offsetof(EVENT_TRACE_PROPERTIES{}.LoggerThreadId)]struct{}
+	_ [unsafe.Offsetof(EVENT_TRACE_PROPERTIES{}.LoggerThreadId) - 104]struct{}
+	// After the pointer-sized LoggerThreadId: 112 on x86, 116 on x64
+	_ [108 + unsafe.Sizeof(uintptr(0)) - unsafe.Offsetof(EVENT_TRACE_PROPERTIES{}.LoggerNameOffset)]struct{}
+	_ [unsafe.Offsetof(EVENT_TRACE_PROPERTIES{}.LoggerNameOffset) - 108 - unsafe.Sizeof(uintptr(0))]struct{}
+)
+
+// Size of one session's properties block in the QueryAllTracesW array: the
+// struct followed by room for a logger name and a log file name of up to
+// MAX_SESSION_NAME_LEN UTF-16 characters each. A multiple of 8, so every
+// block in the array stays aligned.
+const propertySize = propertiesSize + 2*MAX_SESSION_NAME_LEN*2
+
+// Allocate and initialize properties blocks for count sessions, returning
+// the backing buffer and the array of pointers into it that QueryAllTracesW fills
+func allocSessionProperties(count uint32) ([]byte, []uintptr) {
+	buffer := make([]byte, int(count)*int(propertySize))
+	sessionArray := make([]uintptr, count)
+	initSessionProperties(buffer, sessionArray)
+	return buffer, sessionArray
+}
+
+// Set up the header of each properties block in buffer and point
+// sessionArray at them
+func initSessionProperties(buffer []byte, sessionArray []uintptr) {
+	for i := uint32(0); i < uint32(len(sessionArray)); i++ {
+		// Get a pointer to the current session's properties within the buffer
+		props := (*EVENT_TRACE_PROPERTIES)(unsafe.Pointer(&buffer[i*uint32(propertySize)]))
+
+		// Initialize the structure
+		props.Wnode.BufferSize = uint32(propertySize)
+		props.LoggerNameOffset = uint32(propertiesSize)
+		props.LogFileNameOffset = props.LoggerNameOffset + MAX_SESSION_NAME_LEN*2
+
+		sessionArray[i] = uintptr(unsafe.Pointer(props))
+	}
+}
+
+// Properties blocks reused across QueryAllTracesW calls. Polling at a short
+// interval on a machine with hundreds of sessions would otherwise allocate
+// a couple of KB per session on every poll. The blocks only ever grow.
+type propertyBlocks struct {
+	mu           sync.Mutex
+	buffer       []byte
+	sessionArray []uintptr
+}
+
+// Blocks for count sessions, ready for a query. The previous query's data
+// is cleared and the headers set again, since the API overwrote them.
+func (p *propertyBlocks) prepare(count uint32) ([]byte, []uintptr) {
+	if count > uint32(len(p.sessionArray)) {
+		p.buffer, p.sessionArray = allocSessionProperties(count)
+		return p.buffer, p.sessionArray
+	}
+	buffer := p.buffer[:int(count)*int(propertySize)]
+	clear(buffer)
+	initSessionProperties(buffer, p.sessionArray[:count])
+	return buffer, p.sessionArray[:count]
+}
+
+// Sort sessions by host, name, then GUID, keeping the API order for exact
+// duplicates, and number the duplicates so every session has a distinct Key
+func sortByName(sessions []ETWSession) {
+	sort.SliceStable(sessions, func(i, j int) bool {
+		if sessions[i].Host != sessions[j].Host {
+			return sessions[i].Host < sessions[j].Host
+		}
+		if sessions[i].Name != sessions[j].Name {
+			return sessions[i].Name < sessions[j].Name
+		}
+		return sessions[i].Guid < sessions[j].Guid
 	})
-	m.sessions = sessions
-	return sessions, nil
+	assignInstances(sessions)
+}
+
+// CSV columns shared by the one-shot export and the continuous log
+var csvHeader = []string{
+	"Timestamp", "SessionName", "BufferSize_KB", "MinBuffers", "MaxBuffers",
+	"NumberOfBuffers", "FreeBuffers", "BuffersWritten", "EventsLost",
+	"RealTimeBuffersLost", "UtilizationPercent", "TotalMemory_MB", "LogFileName",
+	"LogFileMode",
+}
+
+// One CSV row, in csvHeader order
+func csvRecord(session ETWSession) []string {
+	return []string{
+		session.Timestamp.Format("2006-01-02 15:04:05"),
+		session.Name,
+		strconv.FormatUint(uint64(session.BufferSize), 10),
+		strconv.FormatUint(uint64(session.MinimumBuffers), 10),
+		strconv.FormatUint(uint64(session.MaximumBuffers), 10),
+		strconv.FormatUint(uint64(session.NumberOfBuffers), 10),
+		strconv.FormatUint(uint64(session.FreeBuffers), 10),
+		strconv.FormatUint(uint64(session.BuffersWritten), 10),
+		strconv.FormatUint(uint64(session.EventsLost), 10),
+		strconv.FormatUint(uint64(session.RealTimeBuffersLost), 10),
+		fmt.Sprintf("%.2f", session.UtilizationPercent()),
+		fmt.Sprintf("%.2f", session.TotalMemoryMB()),
+		session.LogFileName,
+		session.LogFileModeString(),
+	}
 }
 
 // Export sessions to CSV
 func (m *ETWBufferMonitor) ExportToCSV(sessions []ETWSession, filename string) error {
-	file, err := os.Create(filename)
+	err := writeFileAtomic(filename, func(w io.Writer) error {
+		return writeCSV(w, sessions)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create CSV file: %w", err)
+		return err
 	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
 
-	// CSV Header
-	header := []string{
-		"Timestamp", "SessionName", "BufferSize_KB", "MinBuffers", "MaxBuffers",
-		"NumberOfBuffers", "FreeBuffers", "BuffersWritten", "EventsLost",
-		"RealTimeBuffersLost", "UtilizationPercent", "TotalMemory_MB", "LogFileName",
-	}
+	notef("Buffer statistics exported to: %s\n", filename)
+	return nil
+}
 
-	if err := writer.Write(header); err != nil {
+// Write sessions as CSV with a header row
+func writeCSV(w io.Writer, sessions []ETWSession) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
 		return fmt.Errorf("failed to write CSV header: %w", err)
 	}
 
 	// Data rows
 	for _, session := range sessions {
-		record := []string{
-			session.Timestamp.Format("2006-01-02 15:04:05"),
-			session.Name,
-			strconv.FormatUint(uint64(session.BufferSize), 10),
-			strconv.FormatUint(uint64(session.MinimumBuffers), 10),
-			strconv.FormatUint(uint64(session.MaximumBuffers), 10),
-			strconv.FormatUint(uint64(session.NumberOfBuffers), 10),
-			strconv.FormatUint(uint64(session.FreeBuffers), 10),
-			strconv.FormatUint(uint64(session.BuffersWritten), 10),
-			strconv.FormatUint(uint64(session.EventsLost), 10),
-			strconv.FormatUint(uint64(session.RealTimeBuffersLost), 10),
-			fmt.Sprintf("%.2f", session.UtilizationPercent()),
-			fmt.Sprintf("%.2f", session.TotalMemoryMB()),
-			session.LogFileName,
-		}
-
-		if err := writer.Write(record); err != nil {
+		if err := writer.Write(csvRecord(session)); err != nil {
 			return fmt.Errorf("failed to write CSV record: %w", err)
 		}
 	}
 
-	fmt.Printf("Buffer statistics exported to: %s\n", filename)
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV file: %w", err)
+	}
 	return nil
 }
 
+// Model for continuous monitoring with its outputs (CSV log, -on-warn) attached
+func (m *ETWBufferMonitor) monitorModel(opts monitorOptions) model {
+	initial := initialModel(m, opts)
+	if opts.logCSV != "" {
+		csvLog, err := newCSVLogger(opts.logCSV, opts.logMaxSize, opts.logRotate, opts.logMaxFiles, opts.flushInterval)
+		if err != nil {
+			fatalf("Error opening CSV log: %v", err)
+		}
+		initial.sinks.add(csvLog)
+	}
+	if opts.serveMetrics != "" {
+		metrics, err := newMetricsSink(opts.serveMetrics)
+		if err != nil {
+			initial.sinks.close()
+			fatalf("Error serving metrics: %v", err)
+		}
+		initial.sinks.add(metrics)
+	}
+	if opts.onWarn != "" {
+		initial.warnHook = newWarnHook(opts.onWarn, opts.onWarnCooldown)
+	}
+	return initial
+}
+
+// Flush and close the model's outputs
+func (m model) closeOutputs() {
+	m.sinks.close()
+}
+
 // Start continuous monitoring with Bubble Tea
-func (m *ETWBufferMonitor) StartMonitoring(intervalSeconds int) {
+func (m *ETWBufferMonitor) StartMonitoring(opts monitorOptions) {
 	// Initialize the Bubble Tea model
-	p := tea.NewProgram(initialModel(intervalSeconds, false))
-
-	// Run the program
-	if _, err := p.Run(); err != nil {
-		log.Fatalf("Error running monitor: %v", err)
+	initial := m.monitorModel(opts)
+	p := tea.NewProgram(initial)
+
+	// Run the program. The outputs are closed before any error is reported, as
+	// fatalf skips deferred calls and buffered rows would be lost; this
+	// also covers Ctrl+C delivered as a signal rather than a key.
+	final, err := p.Run()
+	initial.closeOutputs()
+	if err != nil {
+		fatalf("Error running monitor: %v", err)
 	}
+	final.(model).finishRun(opts)
 }
 
 // Start one-time display with Bubble Tea
-func (m *ETWBufferMonitor) ShowOnce() {
+func (m *ETWBufferMonitor) ShowOnce(opts monitorOptions) {
 	// Initialize the Bubble Tea model for one-time display
-	p := tea.NewProgram(initialModel(1, true))
+	opts.showOnce = true
+	p := tea.NewProgram(initialModel(m, opts))
 
 	// Run the program
 	if _, err := p.Run(); err != nil {
-		log.Fatalf("Error running monitor: %v", err)
+		fatalf("Error running monitor: %v", err)
 	}
 }
 
@@ -575,94 +2855,132 @@ func (m *ETWBufferMonitor) StopMonitoring() {
 	m.monitoring = false
 }
 
-// Show help information
+// Show help information. The option list is generated from the monitor
+// flag set; run "help <command>" for the other commands.
 func showHelp() {
 	fmt.Println("ETW Buffer Monitor v1.0 (Go)")
 	fmt.Println("=============================")
 	fmt.Println()
-	fmt.Println("Usage: ETWBufferMonitor.exe [options]")
+	fmt.Println("Usage: ETWtop.exe [command] [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, c := range commands {
+		fmt.Printf("  %-16s %s\n", c.name, c.summary)
+	}
+	fmt.Printf("  %-16s %s\n", "help [command]", "Show the options of a command")
 	fmt.Println()
-	fmt.Println("Options:")
-	fmt.Println("  -once              Show buffer info once and exit")
-	fmt.Println("  -export [filename] Export to CSV file (default: etw_buffer_stats.csv)")
-	fmt.Println("  -interval [seconds] Monitoring interval in seconds (default: 1)")
-	fmt.Println("  -help              Show this help message")
-	fmt.Println("  (no options)       Start continuous monitoring")
+	fmt.Println("Monitor options (the command name may be omitted):")
+	monitorCommand, _ := findCommand("monitor")
+	fs := monitorCommand.flagSet(newCLIConfig())
+	fs.SetOutput(os.Stdout)
+	fs.PrintDefaults()
 	fmt.Println()
 	fmt.Println("Examples:")
-	fmt.Println("  ETWBufferMonitor.exe                    # Start continuous monitoring")
-	fmt.Println("  ETWBufferMonitor.exe -once              # Show current stats once")
-	fmt.Println("  ETWBufferMonitor.exe -export stats.csv  # Export to CSV")
-	fmt.Println("  ETWBufferMonitor.exe -interval 10       # Monitor with 10-second intervals")
+	fmt.Println("  ETWtop.exe                        # Start continuous monitoring")
+	fmt.Println("  ETWtop.exe -once                  # Show current stats once")
+	fmt.Println("  ETWtop.exe export stats.csv       # Export to CSV")
+	fmt.Println("  ETWtop.exe export -format json    # Export to JSON")
+	fmt.Println("  ETWtop.exe check                  # Exit non-zero when a session needs attention")
+	fmt.Println("  ETWtop.exe -interval 10           # Monitor with 10-second intervals")
+	fmt.Println("  ETWtop.exe -pin MySession         # Watch for a session to start")
+	fmt.Println()
+	fmt.Println("Keys:")
+	fmt.Println("  Up/Down, k/j       Select a session")
+	fmt.Println("  Left/Right, h/l    Select the previous / next block (-grid)")
+	fmt.Println("  PgUp/PgDn          Move the selection 10 rows")
+	fmt.Println("  Home/End, g/G      Select the first / last session")
+	fmt.Println("  s / S              Cycle the sort column / reverse the sort direction")
+	fmt.Println("  b                  Cycle the utilization column: numeric, bar, both")
+	fmt.Println("  Enter / Esc        Open or close the detail pane")
+	fmt.Println("  r                  Refresh the detail pane's snapshot")
+	fmt.Println("  i                  Hide or show idle sessions")
+	fmt.Println("  c                  Switch counters between cumulative and per interval")
+	fmt.Println("  v                  Cycle the view: all, warnings only, changed only")
+	fmt.Println("  u                  Group sessions by name prefix (Enter expands a group)")
+	fmt.Println("  /                  Filter sessions by name (Ctrl+F: fuzzy, Enter: keep, Esc: clear)")
+	fmt.Println("  p                  Pin or unpin the selected session")
+	fmt.Println("  ?                  Show or hide the color legend")
+	fmt.Println("  t / w              Show or hide the summary / warning box")
+	fmt.Println("  R                  Reset accumulated statistics (history, time-to-full estimates)")
+	fmt.Println("  .                  Freeze or unfreeze the selected session at the top")
+	fmt.Println("  q, Ctrl+C          Quit")
 	fmt.Println()
 	fmt.Println("Note: This tool requires administrator privileges to access ETW sessions.")
 }
 
-// Check if running as administrator
-func checkAdminPrivileges() bool {
-	// Try to query sessions as a basic check
-	monitor := NewETWBufferMonitor()
-	_, err := monitor.QueryAllSessions()
-	return err == nil
-}
+// TOKEN_INFORMATION_CLASS value for TOKEN_ELEVATION
+const TokenElevation = 20
 
-func main() {
-	// Check for administrator privileges
-	if !checkAdminPrivileges() {
-		fmt.Println("Warning: This tool requires administrator privileges to access ETW sessions.")
-		fmt.Println("Please run as Administrator for full functionality.")
-		fmt.Println()
+// Whether the process token is elevated. Unlike trying a session query,
+// this is definitive: a query can fail for other reasons, or succeed with
+// limited information on a non-elevated token.
+func processElevated() (bool, error) {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return false, err
+	}
+	var token syscall.Token
+	if err := syscall.OpenProcessToken(process, syscall.TOKEN_QUERY, &token); err != nil {
+		return false, fmt.Errorf("OpenProcessToken: %w", err)
 	}
+	defer token.Close()
 
-	monitor := NewETWBufferMonitor()
+	var elevation uint32 // TOKEN_ELEVATION.TokenIsElevated
+	var returned uint32
+	err = syscall.GetTokenInformation(token, TokenElevation,
+		(*byte)(unsafe.Pointer(&elevation)), uint32(unsafe.Sizeof(elevation)), &returned)
+	if err != nil {
+		return false, fmt.Errorf("GetTokenInformation: %w", err)
+	}
+	return elevation != 0, nil
+}
 
-	// Parse command line arguments
-	if len(os.Args) > 1 {
-		switch strings.ToLower(os.Args[1]) {
-		case "-help", "--help", "-h":
-			showHelp()
-			return
-		case "-once", "--once", "-o":
-			monitor.ShowOnce()
-			return
+// Check if running as administrator. When elevation can't be determined the
+// answer is yes, so the warning only shows for a token known not to be elevated.
+func checkAdminPrivileges() bool {
+	elevated, err := processElevated()
+	return elevated || err != nil
+}
 
-		case "-export", "--export", "-e":
-			filename := "etw_buffer_stats.csv"
-			if len(os.Args) > 2 {
-				filename = os.Args[2]
-			}
+var procGetConsoleOutputCP = kernel32.NewProc("GetConsoleOutputCP")
 
-			fmt.Println("ETW Buffer Monitor - Exporting to CSV")
-			fmt.Println("=====================================")
-			sessions, err := monitor.QueryAllSessions()
-			if err != nil {
-				log.Fatalf("Error querying sessions: %v", err)
-			}
+var (
+	procOpenThread           = kernel32.NewProc("OpenThread")
+	procGetProcessIdOfThread = kernel32.NewProc("GetProcessIdOfThread")
+)
 
-			if err := monitor.ExportToCSV(sessions, filename); err != nil {
-				log.Fatalf("Error exporting to CSV: %v", err)
-			}
-			return
+const THREAD_QUERY_LIMITED_INFORMATION = 0x0800
 
-		case "-interval", "--interval", "-i":
-			intervalSeconds := 1
-			if len(os.Args) > 2 {
-				if interval, err := strconv.Atoi(os.Args[2]); err == nil && interval > 0 {
-					intervalSeconds = interval
-				} else {
-					fmt.Printf("Invalid interval '%s', using default: %d seconds\n", os.Args[2], intervalSeconds)
-				}
-			}
-			monitor.StartMonitoring(intervalSeconds)
-			return
+// Process that owns a thread, or 0 when the thread can't be opened (it
+// exited, or access was denied)
+func threadProcessID(threadID uint32) uint32 {
+	handle, _, _ := procOpenThread.Call(THREAD_QUERY_LIMITED_INFORMATION, 0, uintptr(threadID))
+	if handle == 0 {
+		return 0
+	}
+	defer syscall.CloseHandle(syscall.Handle(handle))
+	pid, _, _ := procGetProcessIdOfThread.Call(handle)
+	return uint32(pid)
+}
 
-		default:
-			fmt.Printf("Unknown option: %s\n", os.Args[1])
-			showHelp()
-			return
-		}
+// Logger thread as shown in the detail pane, with its owning process when it
+// can be resolved; an inactive session has no logger thread
+func loggerThreadText(threadID uint32) string {
+	if threadID == 0 {
+		return "0 (not running)"
 	}
+	if pid := threadProcessID(threadID); pid != 0 {
+		return fmt.Sprintf("%d (process %d)", threadID, pid)
+	}
+	return fmt.Sprintf("%d", threadID)
+}
 
-	// Default: start continuous monitoring
-	monitor.StartMonitoring(1)
+// Code page of the console's output, or 0 when there is no console
+func consoleOutputCodePage() uint32 {
+	cp, _, _ := procGetConsoleOutputCP.Call()
+	return uint32(cp)
+}
+
+func main() {
+	os.Exit(runCLI(os.Args[1:]))
 }
@@ -0,0 +1,737 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+	"unsafe"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+	"github.com/muesli/termenv"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// Sessions covering the row states: healthy, busy, losing events, a
+// real-time consumer falling behind, a private logger and a file logger
+func viewSessions() []ETWSession {
+	return []ETWSession{
+		{Name: "EventLog-Application", BufferSize: 64, MinimumBuffers: 2, MaximumBuffers: 22, NumberOfBuffers: 4, FreeBuffers: 3, BuffersWritten: 1200, LogFileMode: EVENT_TRACE_REAL_TIME_MODE, Guid: "{11111111-0000-0000-0000-000000000001}"},
+		{Name: "NT Kernel Logger", BufferSize: 128, MinimumBuffers: 8, MaximumBuffers: 64, NumberOfBuffers: 60, FreeBuffers: 4, BuffersWritten: 98000, LogFileMode: EVENT_TRACE_REAL_TIME_MODE, EnableFlags: 0x3, Guid: "{9E814AAD-3204-11D2-9A82-006008A86939}"},
+		{Name: "Lossy-Agent", BufferSize: 32, MinimumBuffers: 4, MaximumBuffers: 16, NumberOfBuffers: 16, FreeBuffers: 1, BuffersWritten: 5400, EventsLost: 312, LogFileMode: EVENT_TRACE_REAL_TIME_MODE, Guid: "{11111111-0000-0000-0000-000000000003}"},
+		{Name: "Slow-Consumer", BufferSize: 64, MinimumBuffers: 4, MaximumBuffers: 32, NumberOfBuffers: 10, FreeBuffers: 6, BuffersWritten: 800, RealTimeBuffersLost: 5, LogFileMode: EVENT_TRACE_REAL_TIME_MODE, Guid: "{11111111-0000-0000-0000-000000000004}"},
+		{Name: "Private-Session", BufferSize: 8, MinimumBuffers: 2, MaximumBuffers: 4, NumberOfBuffers: 2, FreeBuffers: 2, LogFileMode: EVENT_TRACE_PRIVATE_LOGGER_MODE, Guid: "{11111111-0000-0000-0000-000000000005}"},
+		{Name: "Disk-Capture", BufferSize: 256, MinimumBuffers: 16, MaximumBuffers: 16, NumberOfBuffers: 16, FreeBuffers: 12, BuffersWritten: 42, LogFileMode: EVENT_TRACE_FILE_MODE_SEQUENTIAL, LogFileName: `C:\Traces\disk.etl`, Guid: "{11111111-0000-0000-0000-000000000006}"},
+	}
+}
+
+// Compare got with testdata/name, or rewrite the file with -update
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("View() differs from %s (go test -update rewrites it)\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// A model as it stands after one poll returned sessions, with the default
+// options changed by configure
+func testModel(sessions []ETWSession, configure func(*monitorOptions)) model {
+	opts := newCLIConfig().opts
+	opts.changeFields, _ = parseChangeFields(defaultChangeFields)
+	if configure != nil {
+		configure(&opts)
+	}
+	m := initialModel(NewETWBufferMonitor(), opts)
+	m.applySessions(sessionsMsg{sessions: sessions})
+	return m
+}
+
+func TestRowWidthWithWideRunes(t *testing.T) {
+	names := []string{
+		"EventLog-Application",
+		"日本語のトレースセッション",
+		"追踪会话名称非常长以至于必须被截断才能放进列里",
+		"🔥 Hot-Trace 🔥",
+		"Mixed-名前-🚀-Session-With-A-Long-Tail",
+		"été-combining",
+	}
+	for _, ascii := range []bool{false, true} {
+		for _, name := range names {
+			session := ETWSession{Name: name, BufferSize: 64, MaximumBuffers: 32, NumberOfBuffers: 8, FreeBuffers: 2}
+			m := testModel([]ETWSession{session}, func(opts *monitorOptions) {
+				opts.ascii = ascii
+				opts.icons = true
+			})
+			line := m.rowLine(displayRow{session: session})
+			if got, want := runewidth.StringWidth(line), m.lineWidth(); got != want {
+				t.Errorf("ascii=%v, %q: row is %d cells wide, want %d\n%s", ascii, name, got, want, line)
+			}
+		}
+	}
+}
+
+func TestDuplicateNameKeys(t *testing.T) {
+	keys := func(sessions []ETWSession) map[string]string {
+		sortByName(sessions)
+		byGUID := make(map[string]string)
+		for _, session := range sessions {
+			byGUID[session.Guid] = session.Key()
+		}
+		return byGUID
+	}
+	first := keys([]ETWSession{
+		{Name: "Dup-Trace", Guid: "{22222222-0000-0000-0000-000000000000}"},
+		{Name: "Other"},
+		{Name: "Dup-Trace", Guid: "{11111111-0000-0000-0000-000000000000}"},
+	})
+	if len(first) != 3 {
+		t.Fatalf("got keys %v, want one per GUID", first)
+	}
+	seen := make(map[string]bool)
+	for _, key := range first {
+		if seen[key] {
+			t.Errorf("key %q used by two sessions", key)
+		}
+		seen[key] = true
+	}
+
+	// The API's order changes between polls; the keys must not
+	second := keys([]ETWSession{
+		{Name: "Dup-Trace", Guid: "{11111111-0000-0000-0000-000000000000}"},
+		{Name: "Dup-Trace", Guid: "{22222222-0000-0000-0000-000000000000}"},
+		{Name: "Other"},
+	})
+	for guid, key := range first {
+		if second[guid] != key {
+			t.Errorf("session %s: key %q after reordering, was %q", guid, second[guid], key)
+		}
+	}
+}
+
+func TestViewGolden(t *testing.T) {
+	lipgloss.SetColorProfile(termenv.Ascii)
+	// A CJK console code page would otherwise widen the box-drawing glyphs
+	runewidth.DefaultCondition.EastAsianWidth = false
+	tests := []struct {
+		golden    string
+		configure func(*monitorOptions)
+	}{
+		{"view_default.golden", nil},
+		{"view_ascii.golden", func(opts *monitorOptions) { opts.ascii = true }},
+		{"view_layout_bottom.golden", func(opts *monitorOptions) { opts.layout = "bottom" }},
+		{"view_grid.golden", func(opts *monitorOptions) { opts.grid = true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			m := testModel(viewSessions(), tt.configure)
+			m.width, m.height = 140, 40
+			m.lastChange = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+			checkGolden(t, tt.golden, m.View())
+		})
+	}
+}
+
+// Stand-in for QueryAllTracesW on a machine running running[i] sessions at
+// its i-th call (the last value repeats). Sessions are named Session-1,
+// Session-2 and so on, and written into blocks as far as the array goes.
+type fakeQueryAllTraces struct {
+	blocks  *propertyBlocks
+	running []uint32
+	probe   uintptr // Probe result instead of the one Windows would give; 0 leaves it
+	data    uintptr // Data call result instead of the one Windows would give; 0 leaves it
+	calls   int
+	names   [][]uint16 // Encoded session names, built as far as needed
+}
+
+func (f *fakeQueryAllTraces) install(t testing.TB) {
+	saved := queryAllTracesW
+	queryAllTracesW = f.call
+	t.Cleanup(func() { queryAllTracesW = saved })
+}
+
+func (f *fakeQueryAllTraces) call(sessionArray *uintptr, capacity uint32, sessionCount *uint32) uintptr {
+	running := f.running[min(f.calls, len(f.running)-1)]
+	f.calls++
+	*sessionCount = running
+	if sessionArray == nil {
+		if f.probe != 0 {
+			return f.probe
+		}
+		if running == 0 {
+			return ERROR_SUCCESS
+		}
+		return ERROR_MORE_DATA
+	}
+	if f.data != 0 {
+		return f.data
+	}
+	for i := uint32(0); i < min(running, capacity); i++ {
+		for len(f.names) <= int(i) {
+			f.names = append(f.names, utf16.Encode([]rune(fmt.Sprintf("Session-%d", len(f.names)+1))))
+		}
+		offset := int(i)*int(propertySize) + int(propertiesSize)
+		for j, c := range f.names[i] {
+			binary.LittleEndian.PutUint16(f.blocks.buffer[offset+2*j:], c)
+		}
+	}
+	if running > capacity {
+		return ERROR_MORE_DATA
+	}
+	return ERROR_SUCCESS
+}
+
+// Logger names of the first count blocks in buffer
+func blockNames(buffer []byte, count uint32) []string {
+	names := make([]string, count)
+	for i := range names {
+		props := (*EVENT_TRACE_PROPERTIES)(unsafe.Pointer(&buffer[i*int(propertySize)]))
+		names[i] = propertyString(props, props.LoggerNameOffset)
+	}
+	return names
+}
+
+func TestQueryAllTracesGrowth(t *testing.T) {
+	tests := []struct {
+		name    string
+		running []uint32 // At the probe, then at each data call
+		want    uint32
+		calls   int
+	}{
+		{"steady", []uint32{3}, 3, 2},
+		{"grows once", []uint32{3, 5, 5}, 5, 3},
+		{"grows twice", []uint32{3, 5, 8, 8}, 8, 4},
+		// Still growing at the last attempt: the allocated sessions, no more
+		{"outgrows the retries", []uint32{3, 5, 8, 12, 20}, 8, 1 + maxQueryAttempts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocks := &propertyBlocks{}
+			fake := &fakeQueryAllTraces{blocks: blocks, running: tt.running}
+			fake.install(t)
+
+			buffer, count, err := queryAllTraces(blocks)
+			if err != nil {
+				t.Fatalf("queryAllTraces: %v", err)
+			}
+			if count != tt.want {
+				t.Errorf("got %d sessions, want %d", count, tt.want)
+			}
+			if fake.calls != tt.calls {
+				t.Errorf("QueryAllTracesW called %d times, want %d", fake.calls, tt.calls)
+			}
+			if len(buffer) < int(count)*int(propertySize) {
+				t.Fatalf("%d sessions in a %d-byte buffer", count, len(buffer))
+			}
+			for i, name := range blockNames(buffer, count) {
+				if want := fmt.Sprintf("Session-%d", i+1); name != want {
+					t.Errorf("block %d holds %q, want %q", i, name, want)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryAllTracesProbe(t *testing.T) {
+	tests := []struct {
+		name    string
+		fake    fakeQueryAllTraces
+		want    uint32
+		wantErr string
+	}{
+		{name: "success with no sessions", fake: fakeQueryAllTraces{running: []uint32{0}}},
+		{name: "more data with no sessions", fake: fakeQueryAllTraces{running: []uint32{0}, probe: ERROR_MORE_DATA}},
+		{name: "more data", fake: fakeQueryAllTraces{running: []uint32{4}}, want: 4},
+		{name: "probe fails", fake: fakeQueryAllTraces{running: []uint32{4}, probe: ERROR_ACCESS_DENIED}, wantErr: "failed to get session count, error: 5"},
+		{name: "data call fails", fake: fakeQueryAllTraces{running: []uint32{4}, data: ERROR_ACCESS_DENIED}, wantErr: "failed to query sessions, error: 5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocks := &propertyBlocks{}
+			fake := tt.fake
+			fake.blocks = blocks
+			fake.install(t)
+
+			_, count, err := queryAllTraces(blocks)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("queryAllTraces: %v", err)
+			}
+			if count != tt.want {
+				t.Errorf("got %d sessions, want %d", count, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatMemory(t *testing.T) {
+	tests := []struct {
+		mb   float64
+		want string
+	}{
+		{0, "0.0 KB"},
+		{0.5, "512.0 KB"},
+		{1023.0 / 1024, "1023.0 KB"},
+		{1023.97 / 1024, "1.0 MB"}, // Would round to 1024.0 KB
+		{1, "1.0 MB"},
+		{1.25, "1.2 MB"},
+		{1023.9, "1023.9 MB"},
+		{1023.97, "1.0 GB"}, // Would round to 1024.0 MB
+		{1024, "1.0 GB"},
+		{1536, "1.5 GB"},
+		{1024 * 1024, "1024.0 GB"},
+	}
+	for _, tt := range tests {
+		if got := formatMemory(tt.mb); got != tt.want {
+			t.Errorf("formatMemory(%v) = %q, want %q", tt.mb, got, tt.want)
+		}
+	}
+}
+
+func TestUtilizationCellWithoutBuffers(t *testing.T) {
+	m := testModel(nil, nil)
+	tests := []struct {
+		name    string
+		session ETWSession
+		want    string
+	}{
+		{"initializing", ETWSession{Name: "Starting", BufferSize: 64}, "n/a"},
+		{"private logger", ETWSession{Name: "Private", LogFileMode: EVENT_TRACE_PRIVATE_LOGGER_MODE}, "n/a"},
+		{"idle", ETWSession{Name: "Idle", BufferSize: 64, NumberOfBuffers: 4, FreeBuffers: 4}, "0.0"},
+		{"busy", ETWSession{Name: "Busy", BufferSize: 64, NumberOfBuffers: 4, FreeBuffers: 1}, "75.0"},
+	}
+	for _, tt := range tests {
+		if got := utilizationCell(m, tt.session); got != tt.want {
+			t.Errorf("%s: utilization %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if !(&ETWSession{Name: "Starting"}).initializing() {
+		t.Error("a session without buffers isn't initializing")
+	}
+}
+
+func TestCompactNumber(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1.0K"},
+		{12345, "12.3K"},
+		{999_949, "999.9K"},
+		{999_999, "1.0M"}, // Would round to 1000.0K
+		{1_000_000, "1.0M"},
+		{1_234_567, "1.2M"},
+		{math.MaxUint32, "4.3G"},
+	}
+	for _, tt := range tests {
+		if got := compactNumber(tt.n); got != tt.want {
+			t.Errorf("compactNumber(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+// The line of view that starts with label, inside the summary box's border
+func summaryLine(t *testing.T, view, label string) string {
+	t.Helper()
+	for _, line := range strings.Split(view, "\n") {
+		if _, rest, ok := strings.Cut(line, label); ok {
+			return strings.TrimSpace(strings.SplitN(rest, "│", 2)[0])
+		}
+	}
+	t.Fatalf("no %q line in:\n%s", label, view)
+	return ""
+}
+
+func TestSummaryLeavesAnomalousOutOfAverage(t *testing.T) {
+	lipgloss.SetColorProfile(termenv.Ascii)
+	m := testModel([]ETWSession{
+		{Name: "Half", BufferSize: 64, NumberOfBuffers: 4, FreeBuffers: 2},
+		{Name: "Full", BufferSize: 64, NumberOfBuffers: 4, FreeBuffers: 0},
+		// Counted as 0% it would pull the average down to 50%
+		{Name: "Anomalous", BufferSize: 64, NumberOfBuffers: 4, FreeBuffers: 6},
+	}, nil)
+	view := m.View()
+
+	if got := summaryLine(t, view, "Avg Utilization:"); got != "75.0%" {
+		t.Errorf("Avg Utilization %q, want 75.0%%", got)
+	}
+	if got := summaryLine(t, view, "Anomalous:"); got != "1 (free > allocated, not averaged)" {
+		t.Errorf("Anomalous %q, want 1 (free > allocated, not averaged)", got)
+	}
+}
+
+func TestSummaryCountsOnlyFilteredSessions(t *testing.T) {
+	lipgloss.SetColorProfile(termenv.Ascii)
+	m := testModel(viewSessions(), nil)
+	for range m.idlePolls {
+		m.applySessions(sessionsMsg{sessions: viewSessions()})
+	}
+	// EventLog-Application and NT Kernel Logger, the first still writing
+	m.filter = "log"
+	sessions := viewSessions()
+	sessions[0].BuffersWritten += 10
+	m.applySessions(sessionsMsg{sessions: sessions})
+	view := m.View()
+
+	if got := summaryLine(t, view, "Total Sessions:"); got != "2 of 6 (filtered)" {
+		t.Errorf("Total Sessions %q, want 2 of 6 (filtered)", got)
+	}
+	if got := summaryLine(t, view, "Active / Idle:"); got != "1 / 1" {
+		t.Errorf("Active / Idle %q, want 1 / 1", got)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	stamp := time.Date(2025, 1, 1, 12, 0, 0, 0, time.Local)
+	sessions := []ETWSession{
+		{Name: "EventLog-System", BufferSize: 64, MinimumBuffers: 2, MaximumBuffers: 22, NumberOfBuffers: 4, FreeBuffers: 1, BuffersWritten: 1200, EventsLost: 3, RealTimeBuffersLost: 1, LogFileMode: EVENT_TRACE_REAL_TIME_MODE, Timestamp: stamp},
+		{Name: "Disk-Capture", BufferSize: 256, MinimumBuffers: 16, MaximumBuffers: 16, NumberOfBuffers: 16, FreeBuffers: 12, LogFileName: `C:\Traces\disk.etl`, LogFileMode: EVENT_TRACE_FILE_MODE_SEQUENTIAL, Timestamp: stamp},
+	}
+	var b bytes.Buffer
+	if err := writeCSV(&b, sessions); err != nil {
+		t.Fatalf("writeCSV: %v", err)
+	}
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatalf("reading the CSV back: %v", err)
+	}
+	if len(records) != len(sessions)+1 {
+		t.Fatalf("got %d records, want a header and %d rows", len(records), len(sessions))
+	}
+	if !slices.Equal(records[0], csvHeader) {
+		t.Errorf("header %q, want %q", records[0], csvHeader)
+	}
+	for i, session := range sessions {
+		if want := csvRecord(session); !slices.Equal(records[i+1], want) {
+			t.Errorf("row %d: %q, want %q", i+1, records[i+1], want)
+		}
+	}
+
+	// The formatted columns, spelled out so a change to csvRecord shows up too
+	want := []string{"2025-01-01 12:00:00", "EventLog-System", "64", "2", "22", "4", "1", "1200", "3", "1", "75.00", "0.25", "", "RealTime"}
+	if !slices.Equal(records[1], want) {
+		t.Errorf("row 1: %q, want %q", records[1], want)
+	}
+}
+
+func TestStaticPollsKeepTheFrame(t *testing.T) {
+	lipgloss.SetColorProfile(termenv.Ascii)
+	m := testModel(viewSessions(), nil)
+	// Settle the per-interval columns and the idle count first
+	for range m.idlePolls {
+		m.applySessions(sessionsMsg{sessions: viewSessions(), duration: 4 * time.Millisecond})
+	}
+	frame := m.View()
+
+	for i, ms := range []int{3, 8, 2, 9, 5, 7, 4, 6, 3, 8, 2, 9} {
+		m.applySessions(sessionsMsg{sessions: viewSessions(), duration: time.Duration(ms) * time.Millisecond})
+		if view := m.View(); view != frame {
+			t.Fatalf("poll %d (query %dms) changed nothing but rendered a new frame:\n%s", i+1, ms, view)
+		}
+	}
+
+	// A slower query must still show up at once
+	m.applySessions(sessionsMsg{sessions: viewSessions(), duration: 40 * time.Millisecond})
+	if got := m.View(); !strings.Contains(got, "Query: <50ms") {
+		t.Errorf("header after a 40ms query doesn't show Query: <50ms:\n%s", got)
+	}
+}
+
+func TestQueryTimeText(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{2 * time.Millisecond, "<10ms"},
+		{9999 * time.Microsecond, "<10ms"},
+		{10 * time.Millisecond, "<25ms"},
+		{49 * time.Millisecond, "<50ms"},
+		{300 * time.Millisecond, "<500ms"},
+		{640 * time.Millisecond, "600ms"},
+		{1260 * time.Millisecond, "1.3s"},
+	}
+	for _, tt := range tests {
+		if got := queryTimeText(tt.d); got != tt.want {
+			t.Errorf("queryTimeText(%s) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestUTF16PtrToStringStopsAtMaxLen(t *testing.T) {
+	// No terminator anywhere in the buffer
+	buffer := utf16.Encode([]rune("ABCDEFGH"))
+	if got := utf16PtrToString(&buffer[0], 5); got != "ABCDE" {
+		t.Errorf("unterminated read with maxLen 5 = %q, want ABCDE", got)
+	}
+	if got := utf16PtrToString(&buffer[0], len(buffer)); got != "ABCDEFGH" {
+		t.Errorf("unterminated read of the whole buffer = %q, want ABCDEFGH", got)
+	}
+
+	terminated := utf16.Encode([]rune("AB\x00CD"))
+	if got := utf16PtrToString(&terminated[0], len(terminated)); got != "AB" {
+		t.Errorf("terminated read = %q, want AB", got)
+	}
+	if got := utf16PtrToString(nil, 10); got != "" {
+		t.Errorf("nil pointer = %q, want \"\"", got)
+	}
+}
+
+// Write s as UTF-16 at offset in a properties block, without a terminator
+func putUTF16(block []byte, offset uint32, s string) {
+	for i, c := range utf16.Encode([]rune(s)) {
+		binary.LittleEndian.PutUint16(block[int(offset)+2*i:], c)
+	}
+}
+
+func TestPropertyString(t *testing.T) {
+	buffer, _ := allocSessionProperties(1)
+	props := (*EVENT_TRACE_PROPERTIES)(unsafe.Pointer(&buffer[0]))
+
+	// A logger name filling its whole area, unterminated, must not run on
+	// into the log file name behind it
+	name := strings.Repeat("L", MAX_SESSION_NAME_LEN)
+	putUTF16(buffer, props.LoggerNameOffset, name)
+	putUTF16(buffer, props.LogFileNameOffset, `C:\Traces\x.etl`)
+	if got := propertyString(props, props.LoggerNameOffset); got != name {
+		t.Errorf("logger name is %d characters, want %d", len(got), len(name))
+	}
+	if got := propertyString(props, props.LogFileNameOffset); got != `C:\Traces\x.etl` {
+		t.Errorf("log file name %q, want C:\\Traces\\x.etl", got)
+	}
+
+	// An unterminated string at the end of the block stops at the block
+	putUTF16(buffer, uint32(propertySize)-8, "TAIL")
+	if got := propertyString(props, uint32(propertySize)-8); got != "TAIL" {
+		t.Errorf("string at the end of the block = %q, want TAIL", got)
+	}
+
+	for _, offset := range []uint32{0, 8, uint32(propertiesSize) - 2, uint32(propertySize), uint32(propertySize) + 100, ^uint32(0)} {
+		if got := propertyString(props, offset); got != "" {
+			t.Errorf("offset %d outside the string area read %q, want \"\"", offset, got)
+		}
+	}
+}
+
+func TestCSVRoundTripQuoting(t *testing.T) {
+	sessions := []ETWSession{
+		{Name: "Agent, Inc. Trace", LogFileName: `C:\Program Files\Agent, Inc\trace.etl`, NumberOfBuffers: 4, FreeBuffers: 1, LogFileMode: EVENT_TRACE_FILE_MODE_SEQUENTIAL | EVENT_TRACE_REAL_TIME_MODE},
+		{Name: `Quoted "Session"`, LogFileName: `D:\logs\"odd" name.etl`, EventsLost: 7, LogFileMode: EVENT_TRACE_FILE_MODE_CIRCULAR | EVENT_TRACE_USE_PAGED_MEMORY},
+		{Name: "Line\nBreak", LogFileName: "E:\\multi\nline.etl", BuffersWritten: 12},
+		{Name: `Back\slash\`, LogFileName: `\\server\share\trace.etl`},
+	}
+	var b bytes.Buffer
+	if err := writeCSV(&b, sessions); err != nil {
+		t.Fatalf("writeCSV: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "export.csv")
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := NewETWBufferMonitor().ImportFromCSV(path)
+	if err != nil {
+		t.Fatalf("ImportFromCSV: %v", err)
+	}
+	if len(imported) != len(sessions) {
+		t.Fatalf("imported %d sessions, want %d", len(imported), len(sessions))
+	}
+	for i, want := range sessions {
+		got := imported[i]
+		if got.Name != want.Name || got.LogFileName != want.LogFileName {
+			t.Errorf("session %d came back as %q, %q; want %q, %q", i, got.Name, got.LogFileName, want.Name, want.LogFileName)
+		}
+		if got.NumberOfBuffers != want.NumberOfBuffers || got.FreeBuffers != want.FreeBuffers ||
+			got.EventsLost != want.EventsLost || got.BuffersWritten != want.BuffersWritten {
+			t.Errorf("session %d: counters changed in the round trip: %+v", i, got)
+		}
+	}
+
+	// LogFileMode holds a comma-separated list, which must stay one field
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatalf("reading the export: %v", err)
+	}
+	column := slices.Index(csvHeader, "LogFileMode")
+	for i, session := range sessions {
+		record := records[i+1]
+		if len(record) != len(csvHeader) {
+			t.Fatalf("row %d has %d fields, want %d", i+1, len(record), len(csvHeader))
+		}
+		if want := session.LogFileModeString(); record[column] != want {
+			t.Errorf("row %d: LogFileMode %q, want %q", i+1, record[column], want)
+		}
+	}
+	if got := records[1][column]; got != "Sequential, RealTime" {
+		t.Errorf("LogFileMode %q, want \"Sequential, RealTime\"", got)
+	}
+}
+
+// QueryAllSessions against a fake QueryAllTracesW, with properties blocks
+// allocated for every query (allocSessionProperties, as before they were
+// kept) or reused across queries (propertyBlocks.prepare)
+func BenchmarkQueryAllSessions(b *testing.B) {
+	for _, count := range []uint32{50, 500} {
+		for _, reuse := range []bool{false, true} {
+			name := fmt.Sprintf("alloc/%d", count)
+			if reuse {
+				name = fmt.Sprintf("reuse/%d", count)
+			}
+			b.Run(name, func(b *testing.B) {
+				monitor := NewETWBufferMonitor()
+				fake := &fakeQueryAllTraces{blocks: &monitor.blocks, running: []uint32{count}}
+				fake.install(b)
+				b.ReportAllocs()
+				var before, after runtime.MemStats
+				runtime.ReadMemStats(&before)
+				for range b.N {
+					if !reuse {
+						monitor.blocks.buffer, monitor.blocks.sessionArray = nil, nil
+					}
+					if _, err := monitor.QueryAllSessions(); err != nil {
+						b.Fatal(err)
+					}
+				}
+				runtime.ReadMemStats(&after)
+				b.ReportMetric(float64(after.NumGC-before.NumGC)*1000/float64(b.N), "GCs/1000op")
+			})
+		}
+	}
+}
+
+func TestAnonymizeMatchesRealNames(t *testing.T) {
+	tag := tagRule{namePattern: namePattern{Pattern: "EventLog-*"}, Tag: "eventlog"}
+	if err := tag.compile(); err != nil {
+		t.Fatal(err)
+	}
+	monitor := NewETWBufferMonitor()
+	monitor.anonymizer = newAnonymizer()
+	sessions := []ETWSession{{Name: "EventLog-Application"}, {Name: "Defender"}}
+	monitor.anonymizer.apply(sessions)
+
+	opts := newCLIConfig().opts
+	opts.changeFields, _ = parseChangeFields(defaultChangeFields)
+	opts.tags = []tagRule{tag}
+	opts.pinned = []string{"Sysmon"}
+	opts.expected = []string{"Defender", "Crowd"}
+	m := initialModel(monitor, opts)
+	m.applySessions(sessionsMsg{sessions: sessions})
+
+	// Query results are numbered first, command-line names when first shown
+	pseudonyms := map[string]string{}
+	for _, row := range m.displayRows() {
+		if row.session.Name == row.session.matchName() {
+			t.Errorf("row %q shows its real name", row.session.Name)
+		}
+		pseudonyms[row.session.matchName()] = row.session.Name
+	}
+	if pseudonyms["EventLog-Application"] != "session-01" || pseudonyms["Defender"] != "session-02" {
+		t.Errorf("query results numbered %q, want session-01 and session-02", pseudonyms)
+	}
+	if got := []string{pseudonyms["Sysmon"], pseudonyms["Crowd"]}; !slices.Contains(got, "session-03") || !slices.Contains(got, "session-04") {
+		t.Errorf("pinned and expected numbered %q, want session-03 and session-04", got)
+	}
+	if got := m.missingExpected(); !slices.Equal(got, []string{pseudonyms["Crowd"]}) {
+		t.Errorf("missing expected = %q, want [%s]", got, pseudonyms["Crowd"])
+	}
+	if rule := m.sessionTag(sessions[0].matchName()); rule == nil || sessions[0].Name != "session-01" {
+		t.Errorf("tag rule didn't match %q under its pseudonym %q", sessions[0].matchName(), sessions[0].Name)
+	}
+}
+
+func TestWriteNDJSONVersion(t *testing.T) {
+	fields, err := parseJSONFields([]string{"name", "events_lost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		fields []int
+	}{{"all fields", nil}, {"trimmed", fields}} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeNDJSON(&buf, viewSessions(), tt.fields); err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			if len(lines) != len(viewSessions()) {
+				t.Fatalf("got %d lines, want one per session", len(lines))
+			}
+			want := fmt.Sprintf(`{"version":%d,`, jsonSchemaVersion)
+			for _, line := range lines {
+				if !strings.HasPrefix(line, want) {
+					t.Errorf("line %s doesn't start with %s", line, want)
+				}
+				var entry map[string]any
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Errorf("line %s: %v", line, err)
+				}
+				if tt.fields != nil && len(entry) != 1+len(tt.fields) {
+					t.Errorf("line %s has %d keys, want version and %d fields", line, len(entry), len(tt.fields))
+				}
+			}
+		})
+	}
+}
+
+func TestExportToJSON(t *testing.T) {
+	saved := quiet
+	quiet = true
+	t.Cleanup(func() { quiet = saved })
+	filename := filepath.Join(t.TempDir(), "sessions.json")
+	if err := NewETWBufferMonitor().ExportToJSON(viewSessions(), filename); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envelope jsonEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Version != jsonSchemaVersion || len(envelope.Sessions) != len(viewSessions()) {
+		t.Fatalf("version %d with %d sessions, want %d with %d", envelope.Version, len(envelope.Sessions), jsonSchemaVersion, len(viewSessions()))
+	}
+	for i, session := range viewSessions() {
+		got := envelope.Sessions[i]
+		if got.Name != session.Name || got.UtilizationPercent != session.UtilizationPercent() || got.TotalMemoryMB != session.TotalMemoryMB() {
+			t.Errorf("session %d = %+v, want %s with its computed fields", i, got, session.Name)
+		}
+		if _, err := time.Parse(time.RFC3339, got.Timestamp); err != nil {
+			t.Errorf("session %d timestamp: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Session name matcher shared by config files: a case-insensitive glob
+// pattern (Microsoft-Windows-*) or a regular expression, exactly one of them
+type namePattern struct {
+	Pattern string `json:"pattern,omitempty"`
+	Regex   string `json:"regex,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// Validate and prepare the matcher after it was decoded
+func (p *namePattern) compile() error {
+	if (p.Pattern == "") == (p.Regex == "") {
+		return fmt.Errorf("needs exactly one of pattern and regex")
+	}
+	if p.Pattern != "" {
+		if _, err := path.Match(p.Pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", p.Pattern)
+		}
+		p.Pattern = strings.ToLower(p.Pattern)
+		return nil
+	}
+	var err error
+	p.regex, err = regexp.Compile("(?i)" + p.Regex)
+	return err
+}
+
+func (p namePattern) matches(name string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(name)
+	}
+	matched, _ := path.Match(p.Pattern, strings.ToLower(name))
+	return matched
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// ERROR_NO_DATA: writing to a pipe whose read end is being closed
+const errNoData syscall.Errno = 232
+
+// Whether err is a write to a pipe whose reader has gone, as when
+// "ETWtop -stream | findstr /m Kernel" stops after its first match. That
+// ends the output normally rather than being a failure.
+func isBrokenPipe(err error) bool {
+	return errors.Is(err, syscall.ERROR_BROKEN_PIPE) || errors.Is(err, errNoData) || errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestIsBrokenPipe(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"ERROR_BROKEN_PIPE", syscall.ERROR_BROKEN_PIPE, true},
+		{"ERROR_NO_DATA", errNoData, true},
+		{"EPIPE", syscall.EPIPE, true},
+		{"wrapped", fmt.Errorf("write /dev/stdout: %w", syscall.ERROR_BROKEN_PIPE), true},
+		{"unrelated", errors.New("disk full"), false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBrokenPipe(tt.err); got != tt.want {
+				t.Errorf("isBrokenPipe(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// Writer whose reader has gone
+type closedPipe struct{ err error }
+
+func (p closedPipe) Write([]byte) (int, error) { return 0, p.err }
+
+func TestStreamStopsCleanlyOnBrokenPipe(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"broken pipe", fmt.Errorf("write /dev/stdout: %w", errNoData), exitOK},
+		{"other error", errors.New("disk full"), exitError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			monitor := NewETWBufferMonitor()
+			fake := &fakeQueryAllTraces{blocks: &monitor.blocks, running: []uint32{3}}
+			fake.install(t)
+			saved := streamOutput
+			streamOutput = closedPipe{tt.err}
+			t.Cleanup(func() { streamOutput = saved })
+
+			opts := newCLIConfig().opts
+			opts.changeFields, _ = parseChangeFields(defaultChangeFields)
+			opts.stream = true
+			opts.count = 2
+			if got := monitor.RunHeadless(opts); got != tt.want {
+				t.Errorf("RunHeadless = %d, want %d", got, tt.want)
+			}
+			// Stopped at the first poll rather than waiting out the interval
+			if fake.calls != 2 {
+				t.Errorf("QueryAllTracesW called %d times, want one query", fake.calls)
+			}
+		})
+	}
+}
+
+func TestStreamKeepsDiagnosticsOffStdout(t *testing.T) {
+	stdout, stderr := os.Stdout, os.Stderr
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedQuiet := quiet
+	t.Cleanup(func() { os.Stdout, os.Stderr, quiet = stdout, stderr, savedQuiet })
+	os.Stdout, os.Stderr = outW, errW
+
+	cfg := newCLIConfig()
+	cfg.opts.stream = true
+	cfg.opts.intervalSeconds = 0
+	cfg.finalize()
+	os.Stdout, os.Stderr = stdout, stderr
+	outW.Close()
+	errW.Close()
+
+	if got, _ := io.ReadAll(outR); len(got) > 0 {
+		t.Errorf("stdout = %q, want nothing before the stream", got)
+	}
+	if got, _ := io.ReadAll(errR); !strings.Contains(string(got), "Invalid interval") {
+		t.Errorf("stderr = %q, want the invalid interval message", got)
+	}
+}
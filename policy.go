@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Inclusive bounds for a buffer setting; either may be left out
+type valueRange struct {
+	Min *uint32 `json:"min,omitempty"`
+	Max *uint32 `json:"max,omitempty"`
+}
+
+func (r *valueRange) contains(value uint32) bool {
+	return r == nil || (r.Min == nil || value >= *r.Min) && (r.Max == nil || value <= *r.Max)
+}
+
+func (r *valueRange) String() string {
+	switch {
+	case r.Min != nil && r.Max != nil:
+		return fmt.Sprintf("%d-%d", *r.Min, *r.Max)
+	case r.Min != nil:
+		return fmt.Sprintf(">= %d", *r.Min)
+	}
+	return fmt.Sprintf("<= %d", *r.Max)
+}
+
+// Expected buffer configuration for sessions matching a name pattern
+type policyRule struct {
+	namePattern
+	BufferSizeKB   *valueRange `json:"buffer_size_kb,omitempty"`
+	MinimumBuffers *valueRange `json:"minimum_buffers,omitempty"`
+	MaximumBuffers *valueRange `json:"maximum_buffers,omitempty"`
+	LostPerMinute  *float64    `json:"lost_per_minute,omitempty"` // Loss budget: events a session may lose per minute
+}
+
+// A -policy file: rules tried in order, the first matching one applies
+type bufferPolicy struct {
+	Sessions []policyRule `json:"sessions"`
+}
+
+// A setting outside its policy range
+type policyViolation struct {
+	field    string
+	actual   uint32
+	expected *valueRange
+}
+
+func (v policyViolation) String() string {
+	return fmt.Sprintf("%s %d (expected %s)", v.field, v.actual, v.expected)
+}
+
+// Load a -policy file
+func loadPolicy(filename string) (*bufferPolicy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var policy bufferPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	for i := range policy.Sessions {
+		if err := policy.Sessions[i].compile(); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %w", filename, i+1, err)
+		}
+	}
+	return &policy, nil
+}
+
+// First rule matching a session name, nil when none does
+func (p *bufferPolicy) rule(name string) *policyRule {
+	if p == nil {
+		return nil
+	}
+	for i := range p.Sessions {
+		if p.Sessions[i].matches(name) {
+			return &p.Sessions[i]
+		}
+	}
+	return nil
+}
+
+// Whether any rule sets a loss budget
+func (p *bufferPolicy) hasLossBudgets() bool {
+	if p == nil {
+		return false
+	}
+	for _, rule := range p.Sessions {
+		if rule.LostPerMinute != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Loss budget of a session in events per minute; ok is false when its
+// policy rule sets none
+func (m model) lossBudget(session ETWSession) (budget float64, ok bool) {
+	rule := m.policy.rule(session.matchName())
+	if rule == nil || rule.LostPerMinute == nil {
+		return 0, false
+	}
+	return *rule.LostPerMinute, true
+}
+
+// Whether a session loses events faster than its budget allows. Sessions
+// without a budget, or without a measured rate yet, are never over it.
+func (m model) overLossBudget(session ETWSession) bool {
+	budget, ok := m.lossBudget(session)
+	if !ok {
+		return false
+	}
+	rate, measured := m.lostPerMinute(session)
+	return measured && rate > budget
+}
+
+// Whether a session's loss calls for a warning: any loss, or for sessions
+// with a loss budget only loss beyond it
+func (m model) lossWarning(session ETWSession) bool {
+	if _, ok := m.lossBudget(session); ok {
+		return m.overLossBudget(session)
+	}
+	return m.losingEvents(session)
+}
+
+// Loss budget state as shown in the detail pane
+func (m model) lossBudgetText(session ETWSession) string {
+	budget, _ := m.lossBudget(session)
+	rate, ok := m.lostPerMinute(session)
+	if !ok {
+		return fmt.Sprintf("%g/min, no rate measured yet", budget)
+	}
+	if rate > budget {
+		return fmt.Sprintf("%g/min, losing %.1f/min, over by %.1f", budget, rate, rate-budget)
+	}
+	return fmt.Sprintf("%g/min, losing %.1f/min, %.1f remaining", budget, rate, budget-rate)
+}
+
+// Settings of a session that drift from its policy rule
+func (m model) policyViolations(session ETWSession) []policyViolation {
+	rule := m.policy.rule(session.matchName())
+	if rule == nil {
+		return nil
+	}
+	var violations []policyViolation
+	for _, check := range []struct {
+		field    string
+		actual   uint32
+		expected *valueRange
+	}{
+		{"BufferSize", session.BufferSize, rule.BufferSizeKB},
+		{"MinimumBuffers", session.MinimumBuffers, rule.MinimumBuffers},
+		{"MaximumBuffers", session.MaximumBuffers, rule.MaximumBuffers},
+	} {
+		if !check.expected.contains(check.actual) {
+			violations = append(violations, policyViolation{check.field, check.actual, check.expected})
+		}
+	}
+	return violations
+}
+
+// Violations of a session as one line
+func describeViolations(violations []policyViolation) string {
+	parts := make([]string, len(violations))
+	for i, violation := range violations {
+		parts[i] = violation.String()
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics in the Prometheus text exposition format, one sample per session
+var prometheusMetrics = []struct {
+	name  string
+	kind  string
+	help  string
+	value func(s ETWSession) float64
+}{
+	{"etw_session_buffers_total", "gauge", "Buffers currently allocated to the session.",
+		func(s ETWSession) float64 { return float64(s.NumberOfBuffers) }},
+	{"etw_session_buffers_free", "gauge", "Allocated buffers that are free.",
+		func(s ETWSession) float64 { return float64(s.FreeBuffers) }},
+	{"etw_session_buffers_written_total", "counter", "Buffers written since the session started.",
+		func(s ETWSession) float64 { return float64(s.BuffersWritten) }},
+	{"etw_session_events_lost_total", "counter", "Events lost since the session started.",
+		func(s ETWSession) float64 { return float64(s.EventsLost) }},
+	{"etw_session_utilization_percent", "gauge", "Share of allocated buffers in use.",
+		func(s ETWSession) float64 { return s.UtilizationPercent() }},
+}
+
+// Escape a label value for the exposition format
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// Label set identifying a session; the GUID tells same-named sessions apart
+func prometheusLabels(session ETWSession) string {
+	labels := fmt.Sprintf(`session="%s"`, escapeLabelValue(session.Name))
+	if session.Guid != "" {
+		labels += fmt.Sprintf(`,guid="%s"`, escapeLabelValue(session.Guid))
+	}
+	if session.Host != "" {
+		labels += fmt.Sprintf(`,host="%s"`, escapeLabelValue(session.Host))
+	}
+	return labels
+}
+
+// Write sessions as Prometheus metrics in the text exposition format
+func writePrometheus(w io.Writer, sessions []ETWSession) error {
+	var b strings.Builder
+	for _, metric := range prometheusMetrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		for _, session := range sessions {
+			fmt.Fprintf(&b, "%s{%s} %g\n", metric.name, prometheusLabels(session), metric.value(session))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Push sessions to a Prometheus Pushgateway, replacing the metrics previously
+// pushed for the same job and instance
+func pushMetrics(gateway, job, instance string, sessions []ETWSession) error {
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	target := strings.TrimSuffix(gateway, "/") + "/metrics/job/" + url.PathEscape(job)
+	if instance != "" {
+		target += "/instance/" + url.PathEscape(instance)
+	}
+
+	var body bytes.Buffer
+	if err := writePrometheus(&body, sessions); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, target, &body)
+	if err != nil {
+		return fmt.Errorf("invalid Pushgateway URL %s: %w", gateway, err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push to %s: %w", target, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pushgateway at %s answered %s: %s", target, resp.Status, strings.TrimSpace(string(detail)))
+	}
+
+	notef("Pushed %d sessions to %s\n", len(sessions), target)
+	return nil
+}
+
+// Path of the metrics served by -serve
+const metricsPath = "/metrics"
+
+// Sink serving the latest poll as Prometheus metrics for a scraper, so the
+// live monitor can be scraped while it runs
+type metricsSink struct {
+	addr     string
+	server   *http.Server
+	mu       sync.Mutex
+	sessions []ETWSession
+}
+
+// Start serving at addr. The listener is opened here so a taken port is
+// reported before monitoring starts.
+func newMetricsSink(addr string) (*metricsSink, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &metricsSink{addr: addr}
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsPath, func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		sessions := s.sessions
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := writePrometheus(w, sessions); err != nil {
+			activityLog.Error("writing metrics failed", "remote", r.RemoteAddr, "error", err)
+		}
+	})
+	s.server = &http.Server{Handler: mux}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			activityLog.Error("metrics server stopped", "addr", addr, "error", err)
+		}
+	}()
+	activityLog.Info("serving metrics", "addr", addr, "path", metricsPath)
+	return s, nil
+}
+
+func (s *metricsSink) Name() string {
+	return "metrics " + s.addr
+}
+
+// Keep the poll for the next scrape
+func (s *metricsSink) Write(sessions []ETWSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = sessions
+	return nil
+}
+
+func (s *metricsSink) Close() error {
+	return s.server.Close()
+}
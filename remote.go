@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Share and file a remote host publishes its exports to when only \\HOST is given
+const (
+	defaultRemoteShare = "ETWtop"
+	defaultRemoteFile  = defaultCSVFile
+)
+
+// Turn a -computer argument into the CSV path to read. A bare host (\\HOST or
+// HOST) maps to the default share; a full UNC or local path is used as is.
+func resolveRemotePath(computer string) string {
+	host := strings.TrimLeft(computer, `\/`)
+	if strings.ContainsAny(host, `\/`) {
+		return computer
+	}
+	return `\\` + host + `\` + defaultRemoteShare + `\` + defaultRemoteFile
+}
+
+// Read sessions from a CSV written by ExportToCSV, typically on a share
+// published by an instance running on another machine
+func (m *ETWBufferMonitor) ImportFromCSV(filename string) ([]ETWSession, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w\n"+
+			"ETW sessions can't be queried remotely. On the remote machine, export with "+
+			"'ETWtop.exe -export <folder>\\%s' (e.g. from a scheduled task), share that folder as '%s', "+
+			"and grant this account read access to the share", filename, err, defaultRemoteFile, defaultRemoteShare)
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSV %s: %w", filename, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("CSV %s is empty", filename)
+	}
+
+	// Locate columns by header name so older exports with fewer columns still load
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	if _, ok := columns["SessionName"]; !ok {
+		return nil, fmt.Errorf("CSV %s is not an ETW buffer export (no SessionName column)", filename)
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	number := func(record []string, name string) uint32 {
+		value, _ := strconv.ParseUint(field(record, name), 10, 32)
+		return uint32(value)
+	}
+
+	sessions := make([]ETWSession, 0, len(records)-1)
+	for _, record := range records[1:] {
+		timestamp, _ := time.ParseInLocation("2006-01-02 15:04:05", field(record, "Timestamp"), time.Local)
+		sessions = append(sessions, ETWSession{
+			Name:                field(record, "SessionName"),
+			BufferSize:          number(record, "BufferSize_KB"),
+			MinimumBuffers:      number(record, "MinBuffers"),
+			MaximumBuffers:      number(record, "MaxBuffers"),
+			NumberOfBuffers:     number(record, "NumberOfBuffers"),
+			FreeBuffers:         number(record, "FreeBuffers"),
+			BuffersWritten:      number(record, "BuffersWritten"),
+			EventsLost:          number(record, "EventsLost"),
+			RealTimeBuffersLost: number(record, "RealTimeBuffersLost"),
+			LogFileName:         field(record, "LogFileName"),
+			Index:               len(sessions), // The export's row order; the API order isn't recorded
+			Timestamp:           timestamp,
+		})
+	}
+	return sessions, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Totals across a whole monitoring run, kept for the closing report.
+// Unlike the history, they survive a statistics reset.
+type runStats struct {
+	started      time.Time
+	polls        int
+	changedPolls int // Polls whose values differed from the previous one
+	maxSessions  int
+	peakMemoryMB float64
+	peakMemoryAt time.Time
+	lost         map[string]uint64 // Events lost during the run, by session key
+	names        map[string]string // Session name by key, for sessions that lost events
+}
+
+func newRunStats() *runStats {
+	return &runStats{
+		started: time.Now(),
+		lost:    make(map[string]uint64),
+		names:   make(map[string]string),
+	}
+}
+
+// Fold the latest query into the run totals
+func (m *model) recordRun() {
+	run := m.run
+	run.polls++
+	run.maxSessions = max(run.maxSessions, len(m.sessions))
+	var memory float64
+	for _, session := range m.sessions {
+		memory += session.TotalMemoryMB()
+		if lost := m.lostDelta(session); lost > 0 {
+			run.lost[session.Key()] += uint64(lost)
+			run.names[session.Key()] = session.Name
+		}
+	}
+	if memory > run.peakMemoryMB {
+		run.peakMemoryMB = memory
+		run.peakMemoryAt = m.lastUpdate
+	}
+}
+
+// Closing report of a run
+func (r *runStats) report(ended time.Time) string {
+	var totalLost uint64
+	worstKey := ""
+	for key, lost := range r.lost {
+		totalLost += lost
+		if worstKey == "" || lost > r.lost[worstKey] || lost == r.lost[worstKey] && key < worstKey {
+			worstKey = key
+		}
+	}
+
+	var b strings.Builder
+	field := func(label string, value string) {
+		b.WriteString(fmt.Sprintf("%-24s %s\n", label+":", value))
+	}
+	b.WriteString("ETW Buffer Monitor - Run Report\n")
+	b.WriteString("===============================\n")
+	field("Started", r.started.Format("2006-01-02 15:04:05"))
+	field("Ended", ended.Format("2006-01-02 15:04:05"))
+	field("Duration", ended.Sub(r.started).Round(time.Second).String())
+	field("Polls", fmt.Sprintf("%d", r.polls))
+	if r.polls > 0 {
+		field("Polls With Changes", fmt.Sprintf("%d (%.0f%%; the table was redrawn only for these)", r.changedPolls, float64(r.changedPolls)/float64(r.polls)*100))
+	}
+	field("Max Sessions", fmt.Sprintf("%d", r.maxSessions))
+	if r.polls > 0 {
+		field("Peak Total Memory", formatMemory(r.peakMemoryMB)+" at "+r.peakMemoryAt.Format("15:04:05"))
+	}
+	field("Events Lost (run)", fmt.Sprintf("%d", totalLost))
+	if worstKey != "" {
+		field("Most Lost", fmt.Sprintf("%s (%d)", r.names[worstKey], r.lost[worstKey]))
+	}
+	return b.String()
+}
+
+// Print the closing report, write it to -run-report and the sessions'
+// history to -export-history when set. With -stream stdout carries only
+// the stream, so all of this is printed to stderr.
+func (m model) finishRun(opts monitorOptions) {
+	var out io.Writer = os.Stdout
+	if opts.stream {
+		out = os.Stderr
+	}
+	report := m.run.report(time.Now())
+	fmt.Fprintln(out)
+	fmt.Fprint(out, report)
+	if opts.exportHistory != "" {
+		if err := m.exportHistory(opts.exportHistory); err != nil {
+			fmt.Fprintf(out, "Error exporting history: %v\n", err)
+			activityLog.Error("history export failed", "file", opts.exportHistory, "error", err)
+		} else {
+			fmt.Fprintf(out, "History exported to: %s\n", opts.exportHistory)
+		}
+	}
+	if opts.runReport == "" {
+		return
+	}
+	err := writeFileAtomic(opts.runReport, func(w io.Writer) error {
+		_, err := io.WriteString(w, report)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(out, "Error writing run report: %v\n", err)
+		activityLog.Error("run report write failed", "file", opts.runReport, "error", err)
+		return
+	}
+	fmt.Fprintf(out, "Run report written to: %s\n", opts.runReport)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Name prefix depth used when the rollup is toggled on without -rollup
+const defaultRollupDepth = 2
+
+// Sessions sharing the first name segments, with their totals
+type sessionGroup struct {
+	prefix   string
+	sessions []ETWSession
+	memory   float64
+	lost     uint64
+}
+
+// First depth dash-separated segments of a session name
+func rollupPrefix(name string, depth int) string {
+	segments := strings.SplitN(name, "-", depth+1)
+	return strings.Join(segments[:min(depth, len(segments))], "-")
+}
+
+// Rows of the rollup view: one row per name prefix, largest memory first,
+// each followed by its sessions in the active sort order when expanded
+func (m model) rollupRows(sessions []ETWSession) []displayRow {
+	groups := make(map[string]*sessionGroup)
+	var order []*sessionGroup
+	for _, session := range sessions {
+		prefix := rollupPrefix(session.Name, m.rollupDepth)
+		group, ok := groups[prefix]
+		if !ok {
+			group = &sessionGroup{prefix: prefix}
+			groups[prefix] = group
+			order = append(order, group)
+		}
+		group.sessions = append(group.sessions, session)
+		group.memory += session.TotalMemoryMB()
+		group.lost += uint64(session.EventsLost)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		if order[i].memory != order[j].memory {
+			return order[i].memory > order[j].memory
+		}
+		return order[i].prefix < order[j].prefix
+	})
+
+	rows := make([]displayRow, 0, len(order))
+	for _, group := range order {
+		rows = append(rows, displayRow{group: group})
+		if m.expandedGroups[group.prefix] {
+			for _, session := range group.sessions {
+				rows = append(rows, displayRow{session: session})
+			}
+		}
+	}
+	return rows
+}
+
+// Table line for a group: its prefix and session count in the name column,
+// totals under the Lost and Memory columns
+func (m model) groupLine(row displayRow) string {
+	marker := "▸ "
+	if m.expandedGroups[row.group.prefix] {
+		marker = "▾ "
+	}
+	if m.ascii {
+		marker = strings.NewReplacer("▸", "+", "▾", "-").Replace(marker)
+	}
+	columns := m.columns()
+	cells := make([]string, len(columns))
+	cells[0] = fmt.Sprintf("%s%s (%d)", marker, row.group.prefix, len(row.group.sessions))
+	for i, col := range columns {
+		switch col.title {
+		case "Lost":
+			cells[i] = strconv.FormatUint(row.group.lost, 10)
+		case "Memory":
+			cells[i] = formatMemory(row.group.memory)
+		}
+	}
+	return m.rowPrefix(row) + formatRow(columns, cells)
+}
+
+// Expand or collapse a group row
+func (m *model) toggleGroup(group *sessionGroup) {
+	if m.expandedGroups[group.prefix] {
+		delete(m.expandedGroups, group.prefix)
+	} else {
+		m.expandedGroups[group.prefix] = true
+	}
+}
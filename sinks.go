@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A consumer of poll results such as the CSV log or the metrics endpoint.
+// Every query is published to all registered sinks, so outputs combine
+// freely with each other and with the TUI or headless loop.
+type sessionSink interface {
+	Name() string
+	Write(sessions []ETWSession) error
+	Close() error
+}
+
+// The sinks one monitor publishes to, with each sink's last failure so it
+// can be shown without stopping the monitor
+type sinkSet struct {
+	sinks []sessionSink
+	errs  []error
+}
+
+func (s *sinkSet) add(sink sessionSink) {
+	s.sinks = append(s.sinks, sink)
+	s.errs = append(s.errs, nil)
+}
+
+// Hand a query result to every sink. A failure is logged when it starts
+// rather than on every poll.
+func (s *sinkSet) publish(sessions []ETWSession) {
+	for i, sink := range s.sinks {
+		err := sink.Write(sessions)
+		if err != nil && s.errs[i] == nil {
+			activityLog.Error("output failed", "output", sink.Name(), "error", err)
+		}
+		s.errs[i] = err
+	}
+}
+
+// Current failures as "name: error", for the status line
+func (s *sinkSet) failures() string {
+	var failures []string
+	for i, err := range s.errs {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", s.sinks[i].Name(), err))
+		}
+	}
+	return strings.Join(failures, " | ")
+}
+
+// Flush and close every sink
+func (s *sinkSet) close() {
+	for _, sink := range s.sinks {
+		if err := sink.Close(); err != nil {
+			activityLog.Error("closing output failed", "output", sink.Name(), "error", err)
+		}
+	}
+}
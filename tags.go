@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+)
+
+// One -tags rule: sessions whose name matches get the tag, and optionally
+// a row tint
+type tagRule struct {
+	namePattern
+	Tag   string `json:"tag"`
+	Color string `json:"color,omitempty"` // Tint for matching rows in the normal state
+}
+
+// Load tag rules from a JSON array, in the order they are tried; the first
+// matching rule wins
+func loadTagRules(filename string) ([]tagRule, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var rules []tagRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Tag == "" {
+			return nil, fmt.Errorf("%s: rule %d has no tag", filename, i+1)
+		}
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("%s: rule %d (%s): %w", filename, i+1, rule.Tag, err)
+		}
+		if rule.Color != "" && !validColor(rule.Color) {
+			return nil, fmt.Errorf("%s: rule %d (%s) has invalid color %q (use 0-255 or #RRGGBB)", filename, i+1, rule.Tag, rule.Color)
+		}
+	}
+	return rules, nil
+}
+
+// First rule matching a session name, nil when none does
+func (m model) sessionTag(name string) *tagRule {
+	for i := range m.tags {
+		if m.tags[i].matches(name) {
+			return &m.tags[i]
+		}
+	}
+	return nil
+}
+
+// Tag column, as wide as the longest tag
+func (m model) tagColumn() column {
+	width := len("Tag") + 1
+	for _, rule := range m.tags {
+		width = max(width, runewidth.StringWidth(rule.Tag)+1)
+	}
+	return column{"Tag", width, func(m model, s ETWSession) string {
+		if rule := m.sessionTag(s.matchName()); rule != nil {
+			return rule.Tag
+		}
+		return ""
+	}}
+}
+
+// Row color for a session in the normal state: its tag's tint if it has one
+func (m model) normalColor(name string) lipgloss.Color {
+	if rule := m.sessionTag(name); rule != nil && rule.Color != "" {
+		return lipgloss.Color(rule.Color)
+	}
+	return colors.normal
+}
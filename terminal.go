@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+// What the terminal ETWtop writes to can display, detected at startup
+type terminalCaps struct {
+	tty      bool   // Stdout is a console rather than a file or pipe
+	codePage uint32 // Console output code page; 0 without a console
+	utf8     bool   // Unicode glyphs render: a UTF-8 code page or Windows Terminal
+	colors   termenv.Profile
+}
+
+func detectTerminal() terminalCaps {
+	var mode uint32
+	caps := terminalCaps{
+		tty:      syscall.GetConsoleMode(syscall.Stdout, &mode) == nil,
+		codePage: consoleOutputCodePage(),
+		colors:   lipgloss.ColorProfile(),
+	}
+	// Windows Terminal renders Unicode whatever the code page says
+	caps.utf8 = caps.codePage == CP_UTF8 || os.Getenv("WT_SESSION") != ""
+	return caps
+}
+
+// Capabilities as listed by -diagnose
+func (c terminalCaps) String() string {
+	output := "file or pipe"
+	if c.tty {
+		output = "console"
+	}
+	charset := "no UTF-8"
+	if c.utf8 {
+		charset = "UTF-8"
+	}
+	colors := map[termenv.Profile]string{
+		termenv.TrueColor: "true color",
+		termenv.ANSI256:   "256 colors",
+		termenv.ANSI:      "16 colors",
+		termenv.Ascii:     "no color",
+	}[c.colors]
+	return fmt.Sprintf("%s, %s (code page %d), %s", output, charset, c.codePage, colors)
+}
+
+// Pick glyphs and colors the terminal can show: ASCII glyphs when output
+// isn't a UTF-8 console, and no color with -no-color or NO_COLOR. Flags given
+// explicitly, such as -ascii=false, win over the detection.
+func applyTerminalDefaults(cfg *cliConfig) {
+	caps := detectTerminal()
+	if !cfg.explicit["ascii"] && (!caps.tty || !caps.utf8) {
+		cfg.opts.ascii = true
+		if caps.tty {
+			notef("Note: the console uses code page %d, not UTF-8, so ASCII glyphs are used (-ascii=false overrides, or run chcp 65001).\n\n", caps.codePage)
+		}
+	}
+
+	noColor := cfg.noColor
+	if !cfg.explicit["no-color"] && os.Getenv("NO_COLOR") != "" {
+		noColor = true
+	}
+	if noColor {
+		lipgloss.SetColorProfile(termenv.Ascii)
+	}
+}
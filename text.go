@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Write the session table as plain text
+func (m *ETWBufferMonitor) ExportToText(sessions []ETWSession, filename string, opts monitorOptions) error {
+	table := m.textTable(sessions, opts)
+	err := writeFileAtomic(filename, func(w io.Writer) error {
+		if _, err := io.WriteString(w, table); err != nil {
+			return fmt.Errorf("failed to write text file: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	notef("Session table exported to: %s\n", filename)
+	return nil
+}
+
+// Render the session table as plain text: the same columns and layout as
+// the monitor, without colors and with session names never truncated
+func (m *ETWBufferMonitor) textTable(sessions []ETWSession, opts monitorOptions) string {
+	opts.showOnce = true
+	table := initialModel(m, opts)
+	table.sessions = sessions
+	table.lastUpdate = time.Now()
+	table.fullWidth = true
+	table.gauge = "numeric"
+	table.showRollup = false
+
+	var b strings.Builder
+	b.WriteString("ETW Buffer Monitor v1.0 (Go)\n")
+	b.WriteString(fmt.Sprintf("%d active sessions\n", len(sessions)))
+	b.WriteString(fmt.Sprintf("Timestamp: %s\n", table.lastUpdate.Format("2006-01-02 15:04:05")))
+	b.WriteString(strings.Repeat("=", table.lineWidth()) + "\n\n")
+	b.WriteString(strings.TrimRight(table.headerPrefix()+formatHeader(table.columns()), " ") + "\n")
+	b.WriteString(strings.Repeat("-", table.lineWidth()) + "\n")
+	for _, row := range table.displayRows() {
+		b.WriteString(strings.TrimRight(table.rowLine(row), " ") + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Colors by session state, shared by the table and its legend, plus the
+// table header and box border. -theme-file overrides any of them.
+type palette struct {
+	rtLost       lipgloss.Color // Real-time consumer falling behind
+	lost         lipgloss.Color // Losing events, or an expected session is missing
+	reconfigured lipgloss.Color // Enable flags changed since the last reset
+	highUtil     lipgloss.Color // Over 80% utilization
+	depleting    lipgloss.Color // Free buffers dropping fast
+	changed      lipgloss.Color // Counters changed since the previous query
+	idle         lipgloss.Color // Wrote no buffers this interval
+	stale        lipgloss.Color // Host stopped answering
+	initializing lipgloss.Color // No buffers allocated yet
+	absent       lipgloss.Color // Pinned but not running
+	normal       lipgloss.Color
+	header       lipgloss.Color // Table column titles
+	border       lipgloss.Color // Summary box border
+}
+
+var colors = palette{
+	rtLost:       lipgloss.Color("201"),
+	lost:         lipgloss.Color("196"),
+	reconfigured: lipgloss.Color("51"),
+	highUtil:     lipgloss.Color("208"),
+	depleting:    lipgloss.Color("214"),
+	changed:      lipgloss.Color("120"),
+	idle:         lipgloss.Color("245"),
+	stale:        lipgloss.Color("244"),
+	initializing: lipgloss.Color("110"),
+	absent:       lipgloss.Color("244"),
+	normal:       lipgloss.Color("252"),
+	header:       lipgloss.Color("33"),
+	border:       lipgloss.Color("240"),
+}
+
+// Theme file keys and the palette entries they set
+func (p *palette) keys() map[string]*lipgloss.Color {
+	return map[string]*lipgloss.Color{
+		"rt-lost":      &p.rtLost,
+		"lost":         &p.lost,
+		"reconfigured": &p.reconfigured,
+		"high-util":    &p.highUtil,
+		"depleting":    &p.depleting,
+		"changed":      &p.changed,
+		"idle":         &p.idle,
+		"stale":        &p.stale,
+		"initializing": &p.initializing,
+		"absent":       &p.absent,
+		"normal":       &p.normal,
+		"header":       &p.header,
+		"border":       &p.border,
+	}
+}
+
+var hexColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Whether a value is a color lipgloss understands: an ANSI 256 color
+// number or a #RGB / #RRGGBB hex code
+func validColor(value string) bool {
+	if hexColor.MatchString(value) {
+		return true
+	}
+	n, err := strconv.Atoi(value)
+	return err == nil && n >= 0 && n <= 255
+}
+
+// Load a theme file into the palette. Each line is "key = color", with
+// blank lines and lines starting with # ignored; keys the file leaves out
+// keep their default. An unknown key or invalid color is an error naming
+// the line, and leaves the palette unchanged.
+func loadThemeFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	theme := colors
+	keys := theme.keys()
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected key = color", filename, i+1)
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.Trim(strings.TrimSpace(value), `"'`)
+		color, known := keys[key]
+		if !known {
+			return fmt.Errorf("%s:%d: unknown key %q", filename, i+1, key)
+		}
+		if !validColor(value) {
+			return fmt.Errorf("%s:%d: invalid color %q for %s (use 0-255 or #RRGGBB)", filename, i+1, value, key)
+		}
+		*color = lipgloss.Color(value)
+	}
+	colors = theme
+	return nil
+}